@@ -0,0 +1,135 @@
+package main
+
+import (
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/benthosdev/benthos/v4/public/bloblang"
+	"github.com/benthosdev/benthos/v4/public/service"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+var envVarRegexp = regexp.MustCompile(`\$\{(\w+)(?::([^}]*))?\}`)
+
+type outputCase struct {
+	Check  string `yaml:"check"`
+	Output struct {
+		GCS struct {
+			Path string `yaml:"path"`
+		} `yaml:"gcp_cloud_storage"`
+	} `yaml:"output"`
+}
+
+var testEnv = map[string]string{
+	"OPS_PORT":        "8081",
+	"GS_BUCKET":       "bucket",
+	"DATA_PRODUCT_ID": "75d44fdc-dffd-42ea-af06-06fa4cb6fdbd",
+	"CREATED_AT":      "20220623",
+}
+
+// loadConfig reads config.yaml into conf with its environment variables
+// replaced by env, testEnv or their defaults.
+func loadConfig(t *testing.T, env map[string]string, conf interface{}) {
+	b, err := os.ReadFile("config.yaml")
+	require.NoError(t, err)
+
+	b = envVarRegexp.ReplaceAllFunc(b, func(ref []byte) []byte {
+		m := envVarRegexp.FindSubmatch(ref)
+		if v, ok := env[string(m[1])]; ok {
+			return []byte(v)
+		}
+		if v, ok := testEnv[string(m[1])]; ok {
+			return []byte(v)
+		}
+		return m[2]
+	})
+	require.NoError(t, yaml.Unmarshal(b, conf))
+}
+
+// loadOutputCases reads the switch cases of the output of config.yaml.
+func loadOutputCases(t *testing.T, env map[string]string) []outputCase {
+	var conf struct {
+		Output struct {
+			Switch struct {
+				Cases []outputCase `yaml:"cases"`
+			} `yaml:"switch"`
+		} `yaml:"output"`
+	}
+	loadConfig(t, env, &conf)
+	require.NotEmpty(t, conf.Output.Switch.Cases)
+	return conf.Output.Switch.Cases
+}
+
+// outputPath returns the path the first matching case writes msg to.
+func outputPath(t *testing.T, cases []outputCase, msg *service.Message) string {
+	for _, c := range cases {
+		if c.Check != "" {
+			exe, err := bloblang.Parse(c.Check)
+			require.NoError(t, err)
+			res, err := msg.BloblangQuery(exe)
+			require.NoError(t, err)
+			v, err := res.AsStructured()
+			require.NoError(t, err)
+			if v != true {
+				continue
+			}
+		}
+		path, err := service.NewInterpolatedString(c.Output.GCS.Path)
+		require.NoError(t, err)
+		return path.String(msg)
+	}
+	t.Fatal("no output case matched")
+	return ""
+}
+
+func TestConfigRoutesRejectsOutOfDataProduct(t *testing.T) {
+	var conf struct {
+		Pipeline struct {
+			Processors []struct {
+				Parquet *struct {
+					Output outputCase `yaml:",inline"`
+				} `yaml:"uw_parquet"`
+			} `yaml:"processors"`
+		} `yaml:"pipeline"`
+	}
+	loadConfig(t, nil, &conf)
+	require.NotNil(t, conf.Pipeline.Processors[0].Parquet)
+
+	// Data files are written by the processor as soon as they are closed.
+	data := service.NewMessage([]byte("PAR1"))
+	dataPath := outputPath(t, []outputCase{conf.Pipeline.Processors[0].Parquet.Output}, data)
+	require.True(t, strings.HasPrefix(dataPath, "75d44fdc-dffd-42ea-af06-06fa4cb6fdbd/75d44fdc-dffd-42ea-af06-06fa4cb6fdbd-20220623_"), dataPath)
+	require.True(t, strings.HasSuffix(dataPath, ".parquet"), dataPath)
+
+	cases := loadOutputCases(t, nil)
+	rejects := service.NewMessage([]byte(`{"error": "invalid"}`))
+	rejects.MetaSet("uw_parquet_rejects_path", "run-1.jsonl")
+	require.Equal(t, "rejects/75d44fdc-dffd-42ea-af06-06fa4cb6fdbd/run-1.jsonl", outputPath(t, cases, rejects))
+
+	cases = loadOutputCases(t, map[string]string{"REJECTS_PREFIX": "quarantine"})
+	require.Equal(t, "quarantine/75d44fdc-dffd-42ea-af06-06fa4cb6fdbd/run-1.jsonl", outputPath(t, cases, rejects))
+}
+
+func TestConfigReadsBackSchemaRecord(t *testing.T) {
+	var conf struct {
+		Pipeline struct {
+			Processors []struct {
+				Parquet *struct {
+					SchemaGuard struct {
+						PreviousSchema string `yaml:"previous_schema"`
+						RecordPath     string `yaml:"record_path"`
+					} `yaml:"schema_guard"`
+				} `yaml:"uw_parquet"`
+			} `yaml:"processors"`
+		} `yaml:"pipeline"`
+	}
+	loadConfig(t, nil, &conf)
+	require.NotNil(t, conf.Pipeline.Processors[0].Parquet)
+	guard := conf.Pipeline.Processors[0].Parquet.SchemaGuard
+
+	require.Equal(t, "gs://bucket/schemas/75d44fdc-dffd-42ea-af06-06fa4cb6fdbd.parquetschema", guard.RecordPath)
+	require.Equal(t, guard.RecordPath, guard.PreviousSchema)
+}
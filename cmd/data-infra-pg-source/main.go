@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"time"
@@ -11,6 +12,7 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
 	"github.com/utilitywarehouse/data-infra-pg-source/internal/benthos/parquet"
+	"github.com/utilitywarehouse/data-infra-pg-source/internal/benthos/pgcdc"
 	"github.com/utilitywarehouse/data-infra-pg-source/internal/benthos/sql"
 	"github.com/utilitywarehouse/data-infra-pg-source/internal/benthos/terminate"
 	"github.com/utilitywarehouse/data-products-definitions/pkg/catalog/v1"
@@ -18,6 +20,8 @@ import (
 
 const appName = "data-infra-pg-source"
 
+var gitHash string
+
 func main() {
 	app := &cli.App{
 		Name:   appName,
@@ -33,6 +37,11 @@ func main() {
 				Value:   "/defs/data-products-definitions/dev",
 				EnvVars: []string{"CATALOG_DIR"},
 			},
+			&cli.DurationFlag{
+				Name:    "catalog-reload-interval",
+				Usage:   "how often catalog-dir is checked for changes, 0 disables reloading",
+				EnvVars: []string{"CATALOG_RELOAD_INTERVAL"},
+			},
 			&cli.StringFlag{
 				Name:    "data-product-id",
 				EnvVars: []string{"DATA_PRODUCT_ID"},
@@ -56,6 +65,11 @@ func main() {
 				Name:    "query",
 				EnvVars: []string{"QUERY"},
 			},
+			&cli.StringFlag{
+				Name:    "source-table",
+				Usage:   "the table the data points are selected from instead of running query",
+				EnvVars: []string{"SOURCE_TABLE"},
+			},
 			&cli.StringFlag{
 				Name:    "gs-bucket",
 				EnvVars: []string{"GS_BUCKET"},
@@ -71,7 +85,7 @@ func main() {
 			},
 		},
 		Action: func(c *cli.Context) error {
-			if err := sql.New(); err != nil {
+			if err := pgcdc.New(); err != nil {
 				return err
 			}
 			if err := terminate.New(); err != nil {
@@ -79,13 +93,35 @@ func main() {
 			}
 
 			os.Setenv("CREATED_AT", fmt.Sprintf("%v", time.Now().Unix()))
-			cat := catalog.New(c.String("catalog-dir"))
-			if err := parquet.New(cat); err != nil {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			var cat parquet.Definitions = catalog.New(c.String("catalog-dir"))
+			if interval := c.Duration("catalog-reload-interval"); interval > 0 {
+				rc, err := parquet.NewReloadingCatalog(c.String("catalog-dir"))
+				if err != nil {
+					return err
+				}
+				go rc.Watch(ctx, interval)
+				cat = rc
+			}
+			if err := sql.New(sql.DataProducts{BuildQuery: parquet.QueryBuilder(cat), CheckColumns: parquet.ColumnChecker(cat)}); err != nil {
+				return err
+			}
+			if err := parquet.New(cat, gitHash); err != nil {
 				return err
 			}
-			service.RunCLI(context.Background())
+			service.RunCLI(ctx)
 			return nil
 		},
+		Commands: []*cli.Command{
+			{
+				Name:      "provenance",
+				Usage:     "print the provenance metadata of parquet files",
+				ArgsUsage: "<file>...",
+				Action:    provenanceAction,
+			},
+		},
 	}
 	if err := app.Run(os.Args); err != nil {
 		logrus.WithError(err).Panic("error running application")
@@ -100,3 +136,22 @@ func beforeFunc(c *cli.Context) error {
 	logrus.SetLevel(logLevel)
 	return nil
 }
+
+func provenanceAction(c *cli.Context) error {
+	enc := json.NewEncoder(os.Stdout)
+	for _, path := range c.Args().Slice() {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		p, err := parquet.ReadProvenance(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%v: %w", path, err)
+		}
+		if err := enc.Encode(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package dataproduct
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/benthosdev/benthos/v4/public/service"
+)
+
+// MappingField is the mapping of data points to source columns, shared by the
+// data_product of the uw_sql_raw input and the processors.
+func MappingField() *service.ConfigField {
+	return service.NewObjectListField("mapping",
+		service.NewStringField("data_point").
+			Description("The data point the value is mapped to."),
+		service.NewStringField("column").
+			Description("The column of the row the value is read from."),
+		service.NewStringField("path").
+			Description("An optional path of the value inside a json column, keys are separated by dots and array elements are selected by their index, e.g. `addresses.0.postcode`.").
+			Default(""),
+		service.NewStringField("default").
+			Description("An optional JSON encoded value the data point is set to when the value is missing or null, e.g. `false` or `\"unknown\"`.").
+			Optional(),
+	).
+		Description("Maps source columns, or values inside json columns, to data points, so that rows don't need to be aliased to the data point names in the query. Columns that aren't mapped are still matched to the data points by name.").
+		Default([]interface{}{}).
+		Example([]interface{}{
+			map[string]interface{}{"data_point": "citizen_id", "column": "legacy_id"},
+			map[string]interface{}{"data_point": "email", "column": "profile", "path": "contact.email", "default": `""`},
+		})
+}
+
+// Mapping maps a data point to a column of the source, or to a value inside a
+// json column if Path is set.
+type Mapping struct {
+	DataPoint string
+	Column    string
+	Path      []string
+	// Default is the JSON encoded value of missing or null values, if
+	// HasDefault.
+	Default    string
+	HasDefault bool
+}
+
+// MappingsFromParsed parses the objects of a MappingField.
+func MappingsFromParsed(confs []*service.ParsedConfig) ([]Mapping, error) {
+	var mappings []Mapping
+	seen := map[string]bool{}
+	for _, conf := range confs {
+		var m Mapping
+		var err error
+		if m.DataPoint, err = conf.FieldString("data_point"); err != nil {
+			return nil, err
+		}
+		if seen[m.DataPoint] {
+			return nil, fmt.Errorf("data point %v is mapped more than once", m.DataPoint)
+		}
+		seen[m.DataPoint] = true
+		if m.Column, err = conf.FieldString("column"); err != nil {
+			return nil, err
+		}
+		path, err := conf.FieldString("path")
+		if err != nil {
+			return nil, err
+		}
+		if path != "" {
+			m.Path = strings.Split(path, ".")
+		}
+		if conf.Contains("default") {
+			if m.Default, err = conf.FieldString("default"); err != nil {
+				return nil, err
+			}
+			m.HasDefault = true
+		}
+		mappings = append(mappings, m)
+	}
+	return mappings, nil
+}
+
+// QueryBuilder returns the query selecting the data points of a data product
+// from a table, or the source columns they are mapped to.
+type QueryBuilder func(dataProductID, table string, mapping []Mapping) (string, error)
+
+// Column is a column of the source of a data product.
+type Column struct {
+	Name string
+	// Type is the lower case name of the postgres type, e.g. int8 or _text
+	// for arrays. Composite types are reported as record.
+	Type     string
+	Nullable *bool
+}
+
+// ColumnChecker returns an error describing every column of a source that
+// doesn't match the data points of a data product, or the source columns they
+// are mapped to.
+type ColumnChecker func(dataProductID string, columns []Column, mapping []Mapping) error
@@ -0,0 +1,422 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/benthosdev/benthos/v4/public/service"
+	"github.com/fraugster/parquet-go/parquet"
+	"github.com/fraugster/parquet-go/parquetschema"
+	"github.com/linkedin/goavro/v2"
+)
+
+func avroProcessorConfig() *service.ConfigSpec {
+	spec := service.NewConfigSpec().
+		Summary("Processor for generating Avro object container files using sql_raw input.").
+		Description("Rows are validated against the definition of the data product like `uw_parquet` does, the Avro schema is derived from its parquet schema. The provenance of a file is written to its metadata, along with its row count, so the rows of a file are held until it is written: a new file is started whenever the current one reaches `max_file_rows` or `max_file_size`. Each file is written to `output` as soon as it is complete, or emitted as a separate message of the resulting batch if it isn't set.").
+		Field(dataProductIDField()).
+		Field(service.NewStringEnumField("compression", goavro.CompressionNullLabel, goavro.CompressionDeflateLabel, goavro.CompressionSnappyLabel).
+			Description("The compression codec of the file blocks.").
+			Default(goavro.CompressionDeflateLabel)).
+		Field(service.NewIntField("max_file_rows").
+			Description("The maximum number of rows written to a file before a new one is started. If value <= 0, files are not limited by rows.").
+			Default(0).
+			Example(1000000)).
+		Field(service.NewIntField("max_file_size").
+			Description("The approximate maximum size in bytes of the uncompressed rows of a file before a new one is started. If value <= 0, files are not limited by size.").
+			Default(0).
+			Example(64 * 1024 * 1024))
+	return withProcessingFields(spec)
+}
+
+func newAvroProcessorFromConfig(cat Definitions, conf *service.ParsedConfig, logger *service.Logger) (*dataProductProcessor, error) {
+	f := &avroFormat{}
+	var err error
+	if f.compression, err = conf.FieldString("compression"); err != nil {
+		return nil, err
+	}
+
+	maxFileRows, err := conf.FieldInt("max_file_rows")
+	if err != nil {
+		return nil, err
+	}
+	f.maxFileRows = int64(maxFileRows)
+
+	maxFileSize, err := conf.FieldInt("max_file_size")
+	if err != nil {
+		return nil, err
+	}
+	f.maxFileSize = int64(maxFileSize)
+
+	r, err := newDataProductProcessorFromConfig(cat, conf, f, logger)
+	if err != nil {
+		return nil, err
+	}
+	resolved, err := r.definition()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := newAvroSchema(resolved.schemaDef); err != nil {
+		return nil, fmt.Errorf("could not derive avro schema of data product %v err=%v", r.dataProductID, err)
+	}
+	return r, nil
+}
+
+// avroFormat writes rows to Avro object container files.
+type avroFormat struct {
+	compression string
+	maxFileRows int64
+	maxFileSize int64
+}
+
+func (f *avroFormat) name() string {
+	return "Avro"
+}
+
+func (f *avroFormat) newEncoder(resolved *resolvedDefinition, provenance Provenance, emit func([]byte) error) (rowEncoder, error) {
+	schema, err := newAvroSchema(resolved.schemaDef)
+	if err != nil {
+		return nil, err
+	}
+	return &avroEncoder{
+		schema:      schema,
+		compression: f.compression,
+		metadata:    provenance.metadata(),
+		maxRows:     f.maxFileRows,
+		maxBytes:    f.maxFileSize,
+		emit:        emit,
+	}, nil
+}
+
+// avroEncoder buffers the rows of a file and writes them once the file reaches
+// its size limits or the encoder is closed, when the row count of its metadata
+// is known.
+type avroEncoder struct {
+	schema      *avroSchema
+	compression string
+	metadata    map[string]string
+	maxRows     int64
+	maxBytes    int64
+	emit        func([]byte) error
+
+	rows []interface{}
+	// size is the size of the binary encoded rows, before compression.
+	size    int64
+	encoded []byte
+}
+
+func (e *avroEncoder) add(row map[string]interface{}) error {
+	native, err := e.schema.root.convert(row)
+	if err != nil {
+		return err
+	}
+	e.rows = append(e.rows, native)
+
+	if e.maxBytes > 0 {
+		if e.encoded, err = e.schema.codec.BinaryFromNative(e.encoded[:0], native); err != nil {
+			return err
+		}
+		e.size += int64(len(e.encoded))
+	}
+	if e.maxRows > 0 && int64(len(e.rows)) >= e.maxRows || e.maxBytes > 0 && e.size >= e.maxBytes {
+		return e.roll()
+	}
+	return nil
+}
+
+// roll writes the buffered rows to a file and emits it.
+func (e *avroEncoder) roll() error {
+	if len(e.rows) == 0 {
+		return nil
+	}
+
+	meta := make(map[string][]byte, len(e.metadata)+1)
+	for k, v := range e.metadata {
+		meta[k] = []byte(v)
+	}
+	meta[metaRowCount] = []byte(strconv.Itoa(len(e.rows)))
+
+	var buf bytes.Buffer
+	w, err := goavro.NewOCFWriter(goavro.OCFConfig{
+		W:               &buf,
+		Codec:           e.schema.codec,
+		CompressionName: e.compression,
+		MetaData:        meta,
+	})
+	if err != nil {
+		return err
+	}
+	if err := w.Append(e.rows); err != nil {
+		return err
+	}
+	e.rows = nil
+	e.size = 0
+	return e.emit(buf.Bytes())
+}
+
+func (e *avroEncoder) close() error {
+	return e.roll()
+}
+
+// avroSchema is the Avro schema of a parquet schema, along with the conversion
+// of the rows of the parquet schema to the native values of goavro.
+type avroSchema struct {
+	codec *goavro.Codec
+	root  *avroType
+}
+
+func newAvroSchema(schemaDef *parquetschema.SchemaDefinition) (*avroSchema, error) {
+	root, err := newAvroRecord(schemaDef.RootColumn, "")
+	if err != nil {
+		return nil, err
+	}
+	schema, err := json.Marshal(root.schema)
+	if err != nil {
+		return nil, err
+	}
+	codec, err := goavro.NewCodec(string(schema))
+	if err != nil {
+		return nil, err
+	}
+	return &avroSchema{codec: codec, root: root}, nil
+}
+
+// avroType is the Avro type of a parquet column.
+type avroType struct {
+	schema interface{}
+	// unionName is the name of the type as a member of a union.
+	unionName string
+	convert   func(v interface{}) (interface{}, error)
+}
+
+// newAvroType returns the type of a column, optional columns are unions with
+// null and repeated columns are arrays.
+func newAvroType(c *parquetschema.ColumnDefinition, namespace string) (*avroType, error) {
+	var t *avroType
+	var err error
+	if len(c.Children) > 0 {
+		t, err = newAvroGroup(c, namespace)
+	} else {
+		t, err = newAvroPrimitive(c.SchemaElement)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch c.SchemaElement.GetRepetitionType() {
+	case parquet.FieldRepetitionType_OPTIONAL:
+		return &avroType{
+			schema:    []interface{}{"null", t.schema},
+			unionName: "union",
+			convert: func(v interface{}) (interface{}, error) {
+				if v == nil {
+					return nil, nil
+				}
+				native, err := t.convert(v)
+				if err != nil {
+					return nil, err
+				}
+				return goavro.Union(t.unionName, native), nil
+			},
+		}, nil
+	case parquet.FieldRepetitionType_REPEATED:
+		return newAvroArray(t, repeatedValues), nil
+	}
+	return t, nil
+}
+
+func newAvroArray(items *avroType, values func(interface{}) ([]interface{}, error)) *avroType {
+	return &avroType{
+		schema:    map[string]interface{}{"type": "array", "items": items.schema},
+		unionName: "array",
+		convert: func(v interface{}) (interface{}, error) {
+			elements, err := values(v)
+			if err != nil {
+				return nil, err
+			}
+			native := make([]interface{}, len(elements))
+			for i, e := range elements {
+				if native[i], err = items.convert(e); err != nil {
+					return nil, err
+				}
+			}
+			return native, nil
+		},
+	}
+}
+
+func newAvroGroup(c *parquetschema.ColumnDefinition, namespace string) (*avroType, error) {
+	switch kindOfGroup(c) {
+	case listGroup:
+		element := c.Children[0].Children[0]
+		items, err := newAvroType(element, namespace)
+		if err != nil {
+			return nil, err
+		}
+		name := element.SchemaElement.GetName()
+		return newAvroArray(items, func(v interface{}) ([]interface{}, error) {
+			entries, err := groupEntries(c, v)
+			if err != nil {
+				return nil, err
+			}
+			elements := make([]interface{}, len(entries))
+			for i, e := range entries {
+				elements[i] = e[name]
+			}
+			return elements, nil
+		}), nil
+	case mapGroup:
+		key, value := c.Children[0].Children[0], c.Children[0].Children[1]
+		if !isString(key.SchemaElement) {
+			return nil, fmt.Errorf("keys of map %v must be strings", c.SchemaElement.GetName())
+		}
+		values, err := newAvroType(value, namespace)
+		if err != nil {
+			return nil, err
+		}
+		keyName, valueName := key.SchemaElement.GetName(), value.SchemaElement.GetName()
+		return &avroType{
+			schema:    map[string]interface{}{"type": "map", "values": values.schema},
+			unionName: "map",
+			convert: func(v interface{}) (interface{}, error) {
+				entries, err := groupEntries(c, v)
+				if err != nil {
+					return nil, err
+				}
+				native := make(map[string]interface{}, len(entries))
+				for _, e := range entries {
+					k, ok := e[keyName].([]byte)
+					if !ok {
+						return nil, fmt.Errorf("unexpected %T key of map %v", e[keyName], c.SchemaElement.GetName())
+					}
+					if native[string(k)], err = values.convert(e[valueName]); err != nil {
+						return nil, err
+					}
+				}
+				return native, nil
+			},
+		}, nil
+	}
+	return newAvroRecord(c, namespace)
+}
+
+// newAvroRecord returns the record of a group, nested records are named after
+// the path of their group so that their names are unique.
+func newAvroRecord(c *parquetschema.ColumnDefinition, namespace string) (*avroType, error) {
+	name := c.SchemaElement.GetName()
+	fullName := name
+	if namespace != "" {
+		fullName = namespace + "." + name
+	}
+
+	fields := make([]interface{}, 0, len(c.Children))
+	types := make([]*avroType, len(c.Children))
+	for i, child := range c.Children {
+		t, err := newAvroType(child, fullName)
+		if err != nil {
+			return nil, err
+		}
+		types[i] = t
+		field := map[string]interface{}{"name": child.SchemaElement.GetName(), "type": t.schema}
+		if child.SchemaElement.GetRepetitionType() == parquet.FieldRepetitionType_OPTIONAL {
+			field["default"] = nil
+		}
+		fields = append(fields, field)
+	}
+
+	schema := map[string]interface{}{"type": "record", "name": name, "fields": fields}
+	if namespace != "" {
+		schema["namespace"] = namespace
+	}
+	return &avroType{
+		schema:    schema,
+		unionName: fullName,
+		convert: func(v interface{}) (interface{}, error) {
+			group, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("unexpected %T value of group %v", v, name)
+			}
+			native := make(map[string]interface{}, len(c.Children))
+			for i, child := range c.Children {
+				childName := child.SchemaElement.GetName()
+				var err error
+				if native[childName], err = types[i].convert(group[childName]); err != nil {
+					return nil, err
+				}
+			}
+			return native, nil
+		},
+	}, nil
+}
+
+// newAvroPrimitive returns the type of a primitive column. Avro timestamps
+// are at most of microsecond precision, nanosecond timestamps are truncated.
+func newAvroPrimitive(e *parquet.SchemaElement) (*avroType, error) {
+	logical := func(v interface{}) (interface{}, error) {
+		return logicalValue(e, v)
+	}
+	identity := func(v interface{}) (interface{}, error) {
+		return v, nil
+	}
+
+	switch {
+	case isDecimal(e):
+		return &avroType{
+			schema: map[string]interface{}{
+				"type":        "bytes",
+				"logicalType": "decimal",
+				"precision":   decimalPrecision(e),
+				"scale":       decimalScale(e),
+			},
+			unionName: "bytes.decimal",
+			convert:   logical,
+		}, nil
+	case isString(e):
+		return &avroType{schema: "string", unionName: "string", convert: logical}, nil
+	case isUUID(e):
+		return &avroType{
+			schema:    map[string]interface{}{"type": "string", "logicalType": "uuid"},
+			unionName: "string",
+			convert:   logical,
+		}, nil
+	case isDate(e):
+		return &avroType{
+			schema:    map[string]interface{}{"type": "int", "logicalType": "date"},
+			unionName: "int.date",
+			convert:   logical,
+		}, nil
+	}
+
+	switch timestampUnit(e) {
+	case millis:
+		return &avroType{
+			schema:    map[string]interface{}{"type": "long", "logicalType": "timestamp-millis"},
+			unionName: "long.timestamp-millis",
+			convert:   logical,
+		}, nil
+	case micros, nanos:
+		return &avroType{
+			schema:    map[string]interface{}{"type": "long", "logicalType": "timestamp-micros"},
+			unionName: "long.timestamp-micros",
+			convert:   logical,
+		}, nil
+	}
+
+	switch e.GetType() {
+	case parquet.Type_BOOLEAN:
+		return &avroType{schema: "boolean", unionName: "boolean", convert: identity}, nil
+	case parquet.Type_INT32:
+		return &avroType{schema: "int", unionName: "int", convert: identity}, nil
+	case parquet.Type_INT64:
+		return &avroType{schema: "long", unionName: "long", convert: identity}, nil
+	case parquet.Type_FLOAT:
+		return &avroType{schema: "float", unionName: "float", convert: identity}, nil
+	case parquet.Type_DOUBLE:
+		return &avroType{schema: "double", unionName: "double", convert: identity}, nil
+	case parquet.Type_BYTE_ARRAY, parquet.Type_FIXED_LEN_BYTE_ARRAY:
+		return &avroType{schema: "bytes", unionName: "bytes", convert: identity}, nil
+	}
+	return nil, fmt.Errorf("unsupported type %v of column %v", e.GetType(), e.GetName())
+}
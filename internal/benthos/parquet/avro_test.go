@@ -0,0 +1,215 @@
+package parquet
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/big"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/benthosdev/benthos/v4/public/service"
+	"github.com/fraugster/parquet-go/parquetschema"
+	"github.com/linkedin/goavro/v2"
+	"github.com/utilitywarehouse/data-products-definitions/pkg/catalog/v1"
+)
+
+const testLogicalSchema = `message test {
+	required binary id (STRING);
+	optional int64 created_at (TIMESTAMP(MICROS, true));
+	optional int32 birthday (DATE);
+	optional int64 amount (DECIMAL(10, 2));
+	optional group tags (LIST) {
+		repeated group list {
+			required binary element (STRING);
+		}
+	}
+	optional group attributes (MAP) {
+		repeated group key_value {
+			required binary key (STRING);
+			optional int64 value;
+		}
+	}
+	optional group address {
+		required binary city (STRING);
+		optional boolean verified;
+	}
+}`
+
+func testLogicalRow() map[string]interface{} {
+	return map[string]interface{}{
+		"id":         []byte("a"),
+		"created_at": int64(1600000000000000),
+		"birthday":   int32(10957),
+		"amount":     int64(12345),
+		"tags": map[string]interface{}{
+			"list": []map[string]interface{}{{"element": []byte("x")}, {"element": []byte("y")}},
+		},
+		"attributes": map[string]interface{}{
+			"key_value": []map[string]interface{}{{"key": []byte("k"), "value": int64(1)}},
+		},
+		"address": map[string]interface{}{"city": []byte("London")},
+	}
+}
+
+func TestAvroSchema(t *testing.T) {
+	schemaDef, err := parquetschema.ParseSchemaDefinition(testLogicalSchema)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	schema, err := newAvroSchema(schemaDef)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	native, err := schema.root.convert(testLogicalRow())
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	b, err := schema.codec.BinaryFromNative(nil, native)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	decoded, _, err := schema.codec.NativeFromBinary(b)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	record := decoded.(map[string]interface{})
+
+	if record["id"] != "a" {
+		t.Fatalf("Expected id a got %v", record["id"])
+	}
+	createdAt := record["created_at"].(map[string]interface{})["long.timestamp-micros"].(time.Time)
+	if !createdAt.Equal(time.Unix(1600000000, 0)) {
+		t.Fatalf("Unexpected created_at %v", createdAt)
+	}
+	birthday := record["birthday"].(map[string]interface{})["int.date"].(time.Time)
+	if birthday.Format("2006-01-02") != "2000-01-01" {
+		t.Fatalf("Unexpected birthday %v", birthday)
+	}
+	amount := record["amount"].(map[string]interface{})["bytes.decimal"].(*big.Rat)
+	if amount.FloatString(2) != "123.45" {
+		t.Fatalf("Unexpected amount %v", amount)
+	}
+	tags := record["tags"].(map[string]interface{})["array"].([]interface{})
+	if len(tags) != 2 || tags[1] != "y" {
+		t.Fatalf("Unexpected tags %v", tags)
+	}
+	attributes := record["attributes"].(map[string]interface{})["map"].(map[string]interface{})
+	if fmt.Sprint(attributes["k"]) != "map[long:1]" {
+		t.Fatalf("Unexpected attributes %v", attributes)
+	}
+	address := record["address"].(map[string]interface{})["test.address"].(map[string]interface{})
+	if address["city"] != "London" || address["verified"] != nil {
+		t.Fatalf("Unexpected address %v", address)
+	}
+}
+
+func TestAvroProcessor(t *testing.T) {
+	conf, err := avroProcessorConfig().ParseYAML(`dataProductID: 75d44fdc-dffd-42ea-af06-06fa4cb6fdbd`, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	proc, err := newAvroProcessorFromConfig(catalog.New("../../../testassets/datadefinitions"), conf, service.MockResources().Logger())
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	batch := service.MessageBatch{
+		service.NewMessage([]byte(fmt.Sprintf(`{"citizen_id": "%s"}`, expectedCitizenID))),
+		service.NewMessage([]byte(fmt.Sprintf(`{"citizen_id": "%s"}`, expectedCitizenID))),
+	}
+	result, err := proc.ProcessBatch(context.Background(), batch)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(result) != 1 || len(result[0]) != 1 {
+		t.Fatalf("Expected a batch of 1 file")
+	}
+	payload, err := result[0][0].AsBytes()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	r, err := goavro.NewOCFReader(bytes.NewReader(payload))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if id := string(r.MetaData()[metaDataProductID]); id != expectedCitizenID {
+		t.Fatalf("Expected data product id %v got %v", expectedCitizenID, id)
+	}
+	if rows := string(r.MetaData()[metaRowCount]); rows != "2" {
+		t.Fatalf("Expected row count 2 got %v", rows)
+	}
+	var rows int
+	for r.Scan() {
+		row, err := r.Read()
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if citizenID := row.(map[string]interface{})["citizen_id"]; citizenID != expectedCitizenID {
+			t.Fatalf("Expected %s got %s", expectedCitizenID, citizenID)
+		}
+		rows++
+	}
+	if rows != 2 {
+		t.Fatalf("Expected 2 rows got %v", rows)
+	}
+}
+
+func TestAvroProcessorRollsFiles(t *testing.T) {
+	for _, tc := range []struct {
+		conf          string
+		expectedFiles []int
+	}{
+		{"max_file_rows: 2", []int{2, 2, 1}},
+		// Every row exceeds the size on its own.
+		{"max_file_size: 1", []int{1, 1, 1, 1, 1}},
+	} {
+		conf, err := avroProcessorConfig().ParseYAML("dataProductID: 75d44fdc-dffd-42ea-af06-06fa4cb6fdbd\n"+tc.conf, nil)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		proc, err := newAvroProcessorFromConfig(catalog.New("../../../testassets/datadefinitions"), conf, service.MockResources().Logger())
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+
+		batch := service.MessageBatch{}
+		for i := 0; i < 5; i++ {
+			batch = append(batch, service.NewMessage([]byte(fmt.Sprintf(`{"citizen_id": "%s"}`, expectedCitizenID))))
+		}
+		result, err := proc.ProcessBatch(context.Background(), batch)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if len(result) != 1 || len(result[0]) != len(tc.expectedFiles) {
+			t.Fatalf("%v: expected a batch of %v files", tc.conf, len(tc.expectedFiles))
+		}
+
+		for i, expectedRows := range tc.expectedFiles {
+			payload, err := result[0][i].AsBytes()
+			if err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			r, err := goavro.NewOCFReader(bytes.NewReader(payload))
+			if err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			if rows := string(r.MetaData()[metaRowCount]); rows != strconv.Itoa(expectedRows) {
+				t.Fatalf("%v: expected file %v to have a row count of %v got %v", tc.conf, i, expectedRows, rows)
+			}
+			var rows int
+			for r.Scan() {
+				if _, err := r.Read(); err != nil {
+					t.Fatalf("Unexpected error %v", err)
+				}
+				rows++
+			}
+			if rows != expectedRows {
+				t.Fatalf("%v: expected file %v to have %v rows got %v", tc.conf, i, expectedRows, rows)
+			}
+		}
+	}
+}
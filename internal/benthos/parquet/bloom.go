@@ -0,0 +1,124 @@
+package parquet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/fraugster/parquet-go/parquet"
+)
+
+const (
+	bloomBlockBytes       = 32
+	bloomMinBytes         = bloomBlockBytes
+	bloomMaxBytes         = 128 * 1024 * 1024
+	defaultBloomFilterFPP = 0.01
+)
+
+// bloomSalt are the salts of the split block bloom filter of the parquet
+// specification.
+var bloomSalt = [8]uint32{
+	0x47b6137b, 0x44974d91, 0x8824ad5b, 0xa2b7289d,
+	0x705495c7, 0x2df1424b, 0x9efc4947, 0x5c6bfb31,
+}
+
+// bloomFilter is a split block bloom filter, the only algorithm defined by the
+// parquet specification, of values hashed with xxHash64.
+type bloomFilter struct {
+	blocks [][8]uint32
+}
+
+// newBloomFilter sizes a filter for the number of distinct values and the
+// false positive probability.
+func newBloomFilter(distinct int64, fpp float64) *bloomFilter {
+	bits := -8 * float64(distinct) / math.Log(1-math.Pow(fpp, 1.0/8))
+	n := bloomMinBytes
+	for float64(n*8) < bits && n < bloomMaxBytes {
+		n *= 2
+	}
+	return &bloomFilter{blocks: make([][8]uint32, n/bloomBlockBytes)}
+}
+
+func (f *bloomFilter) insert(h uint64) {
+	block := &f.blocks[((h>>32)*uint64(len(f.blocks)))>>32]
+	key := uint32(h)
+	for i, salt := range bloomSalt {
+		block[i] |= 1 << ((key * salt) >> 27)
+	}
+}
+
+func (f *bloomFilter) check(h uint64) bool {
+	block := &f.blocks[((h>>32)*uint64(len(f.blocks)))>>32]
+	key := uint32(h)
+	for i, salt := range bloomSalt {
+		if block[i]&(1<<((key*salt)>>27)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// bitset returns the little endian words of the filter.
+func (f *bloomFilter) bitset() []byte {
+	b := make([]byte, len(f.blocks)*bloomBlockBytes)
+	for i, block := range f.blocks {
+		for j, word := range block {
+			binary.LittleEndian.PutUint32(b[i*bloomBlockBytes+j*4:], word)
+		}
+	}
+	return b
+}
+
+func (f *bloomFilter) header() *parquet.BloomFilterHeader {
+	return &parquet.BloomFilterHeader{
+		NumBytes:    int32(len(f.blocks) * bloomBlockBytes),
+		Algorithm:   &parquet.BloomFilterAlgorithm{BLOCK: &parquet.SplitBlockAlgorithm{}},
+		Hash:        &parquet.BloomFilterHash{XXHASH: &parquet.XxHash{}},
+		Compression: &parquet.BloomFilterCompression{UNCOMPRESSED: &parquet.Uncompressed{}},
+	}
+}
+
+// bloomHash hashes the plain encoding of a value, as read back from a column
+// of the given type.
+func bloomHash(typ parquet.Type, v interface{}) (uint64, error) {
+	var b []byte
+	switch typ {
+	case parquet.Type_INT32:
+		i, ok := v.(int32)
+		if !ok {
+			return 0, fmt.Errorf("unexpected %T value of INT32 column", v)
+		}
+		b = make([]byte, 4)
+		binary.LittleEndian.PutUint32(b, uint32(i))
+	case parquet.Type_INT64:
+		i, ok := v.(int64)
+		if !ok {
+			return 0, fmt.Errorf("unexpected %T value of INT64 column", v)
+		}
+		b = make([]byte, 8)
+		binary.LittleEndian.PutUint64(b, uint64(i))
+	case parquet.Type_FLOAT:
+		f, ok := v.(float32)
+		if !ok {
+			return 0, fmt.Errorf("unexpected %T value of FLOAT column", v)
+		}
+		b = make([]byte, 4)
+		binary.LittleEndian.PutUint32(b, math.Float32bits(f))
+	case parquet.Type_DOUBLE:
+		f, ok := v.(float64)
+		if !ok {
+			return 0, fmt.Errorf("unexpected %T value of DOUBLE column", v)
+		}
+		b = make([]byte, 8)
+		binary.LittleEndian.PutUint64(b, math.Float64bits(f))
+	case parquet.Type_BYTE_ARRAY, parquet.Type_FIXED_LEN_BYTE_ARRAY:
+		var ok bool
+		if b, ok = v.([]byte); !ok {
+			return 0, fmt.Errorf("unexpected %T value of %v column", v, typ)
+		}
+	default:
+		return 0, fmt.Errorf("bloom filters are not supported for %v columns", typ)
+	}
+	return xxhash.Sum64(b), nil
+}
@@ -0,0 +1,117 @@
+package parquet
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// topErrors is the number of distinct errors listed per data point when the
+// budget is exceeded.
+const topErrors = 3
+
+// errorBudget tolerates invalid rows across all the batches of a run while
+// they stay under a number of rows or a ratio of the rows processed.
+type errorBudget struct {
+	maxRows  int64
+	maxRatio float64
+
+	mu      sync.Mutex
+	rows    int64
+	invalid int64
+	// errors counts the invalid rows per data point and error.
+	errors map[string]map[string]int64
+}
+
+// newErrorBudget returns a budget of maxRows invalid rows or a maxRatio of
+// invalid rows, a negative limit is ignored.
+func newErrorBudget(maxRows int64, maxRatio float64) *errorBudget {
+	return &errorBudget{
+		maxRows:  maxRows,
+		maxRatio: maxRatio,
+		errors:   map[string]map[string]int64{},
+	}
+}
+
+// add records the rows of a batch and its invalid rows, it returns an error
+// summarising the invalid rows of the run once the budget is exceeded.
+func (b *errorBudget) add(rows int64, invalid []*invalidRowError) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.rows += rows
+	b.invalid += int64(len(invalid))
+	for _, e := range invalid {
+		counts, ok := b.errors[e.dataPoint]
+		if !ok {
+			counts = map[string]int64{}
+			b.errors[e.dataPoint] = counts
+		}
+		counts[e.err.Error()]++
+	}
+
+	if b.maxRows >= 0 && b.invalid > b.maxRows {
+		return fmt.Errorf("%v invalid rows exceed max_invalid_rows %v, %v", b.invalid, b.maxRows, b.summary())
+	}
+	if b.maxRatio >= 0 && b.rows > 0 && float64(b.invalid)/float64(b.rows) > b.maxRatio {
+		return fmt.Errorf("%v invalid rows out of %v exceed max_invalid_ratio %v, %v", b.invalid, b.rows, b.maxRatio, b.summary())
+	}
+	return nil
+}
+
+type errorCount struct {
+	key   string
+	count int64
+}
+
+func sortedCounts(counts map[string]int64) []errorCount {
+	sorted := make([]errorCount, 0, len(counts))
+	for k, c := range counts {
+		sorted = append(sorted, errorCount{key: k, count: c})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].count != sorted[j].count {
+			return sorted[i].count > sorted[j].count
+		}
+		return sorted[i].key < sorted[j].key
+	})
+	return sorted
+}
+
+// summary lists the data points by number of invalid rows, with their most
+// frequent errors.
+func (b *errorBudget) summary() string {
+	totals := map[string]int64{}
+	for dp, counts := range b.errors {
+		for _, c := range counts {
+			totals[dp] += c
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString("top errors:")
+	for i, dp := range sortedCounts(totals) {
+		if i > 0 {
+			sb.WriteString(";")
+		}
+		name := dp.key
+		if name == "" {
+			name = "<row>"
+		}
+		fmt.Fprintf(&sb, " %v (%v rows):", name, dp.count)
+
+		errs := sortedCounts(b.errors[dp.key])
+		for j, e := range errs {
+			if j == topErrors {
+				fmt.Fprintf(&sb, " and %v more", len(errs)-topErrors)
+				break
+			}
+			if j > 0 {
+				sb.WriteString(",")
+			}
+			fmt.Fprintf(&sb, " %v x%v", e.key, e.count)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,58 @@
+package parquet
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func invalidRows(dataPoint, err string, n int) []*invalidRowError {
+	rows := make([]*invalidRowError, 0, n)
+	for i := 0; i < n; i++ {
+		rows = append(rows, &invalidRowError{dataPoint: dataPoint, err: errors.New(err)})
+	}
+	return rows
+}
+
+func TestErrorBudgetMaxRows(t *testing.T) {
+	b := newErrorBudget(3, -1)
+	if err := b.add(100, invalidRows("citizen_id", "invalid UUID length: 4", 2)); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if err := b.add(100, invalidRows("citizen_id", "missing required data point", 1)); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	invalid := append(invalidRows("consent_references", "nested data point should be of type json", 1), invalidRows("", "unexpected message type string", 1)...)
+	err := b.add(100, invalid)
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+	expected := "5 invalid rows exceed max_invalid_rows 3, top errors: citizen_id (3 rows): invalid UUID length: 4 x2, missing required data point x1; <row> (1 rows): unexpected message type string x1; consent_references (1 rows): nested data point should be of type json x1"
+	if err.Error() != expected {
+		t.Fatalf("Expected %v got %v", expected, err)
+	}
+}
+
+func TestErrorBudgetMaxRatio(t *testing.T) {
+	b := newErrorBudget(-1, 0.1)
+	if err := b.add(100, invalidRows("citizen_id", "invalid UUID length: 4", 10)); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	err := b.add(10, invalidRows("citizen_id", "invalid UUID length: 4", 2))
+	if err == nil || !strings.Contains(err.Error(), "12 invalid rows out of 110 exceed max_invalid_ratio 0.1") {
+		t.Fatalf("Expected ratio error got %v", err)
+	}
+}
+
+func TestErrorBudgetTopErrors(t *testing.T) {
+	b := newErrorBudget(0, -1)
+	var invalid []*invalidRowError
+	for i, e := range []string{"a", "b", "c", "d", "e"} {
+		invalid = append(invalid, invalidRows("citizen_id", e, 5-i)...)
+	}
+	err := b.add(100, invalid)
+	if err == nil || !strings.HasSuffix(err.Error(), "citizen_id (15 rows): a x5, b x4, c x3 and 2 more") {
+		t.Fatalf("Expected top errors got %v", err)
+	}
+}
@@ -0,0 +1,181 @@
+package parquet
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fraugster/parquet-go/parquetschema"
+	"github.com/sirupsen/logrus"
+	"github.com/utilitywarehouse/data-products-definitions/pkg/catalog/v1"
+)
+
+// Definitions resolves data product definitions by id, it is satisfied by
+// catalog.Catalog.
+type Definitions interface {
+	GetByID(id string) (*catalog.Definition, error)
+}
+
+// ReloadingCatalog is a catalog that is loaded again whenever the files of its
+// directory change.
+type ReloadingCatalog struct {
+	dir string
+
+	mu          sync.RWMutex
+	cat         catalog.Catalog
+	fingerprint string
+	version     uint64
+}
+
+// NewReloadingCatalog loads the catalog of dir.
+func NewReloadingCatalog(dir string) (*ReloadingCatalog, error) {
+	c := &ReloadingCatalog{dir: dir}
+	fingerprint, err := c.fingerprintDir()
+	if err != nil {
+		return nil, err
+	}
+	c.cat = catalog.New(dir)
+	c.fingerprint = fingerprint
+	return c, nil
+}
+
+func (c *ReloadingCatalog) GetByID(id string) (*catalog.Definition, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cat.GetByID(id)
+}
+
+// Version is incremented every time the catalog is reloaded.
+func (c *ReloadingCatalog) Version() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.version
+}
+
+// Watch checks the directory for changes every interval until ctx is done.
+func (c *ReloadingCatalog) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.reload(); err != nil {
+				logrus.WithError(err).Errorf("failed to reload catalog %v", c.dir)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// reload loads the catalog again if the files of the directory changed.
+func (c *ReloadingCatalog) reload() error {
+	fingerprint, err := c.fingerprintDir()
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if fingerprint == c.fingerprint {
+		return nil
+	}
+	c.cat = catalog.New(c.dir)
+	c.fingerprint = fingerprint
+	c.version++
+	logrus.Infof("reloaded catalog %v", c.dir)
+	return nil
+}
+
+// fingerprintDir hashes the paths, sizes and modification times of the files
+// of the directory. The directory is resolved first, as it is often a symlink
+// that is swapped when the definitions are synced.
+func (c *ReloadingCatalog) fingerprintDir() (string, error) {
+	root, err := filepath.EvalSymlinks(c.dir)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	fmt.Fprintln(h, root)
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(h, path, info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// resolvedDefinition is a data product definition along with its parquet
+// schema, cached across batches.
+type resolvedDefinition struct {
+	def        *catalog.Definition
+	schemaDef  *parquetschema.SchemaDefinition
+	schemaHash string
+	version    uint64
+}
+
+// definition returns the cached definition of the data product, resolving it
+// again if the catalog has been reloaded since. Once files have been published
+// a reloaded definition with another schema fails the run, as its files would
+// be published under two schemas.
+func (r *dataProductProcessor) definition() (*resolvedDefinition, error) {
+	var version uint64
+	if c, ok := r.catalog.(*ReloadingCatalog); ok {
+		version = c.Version()
+	}
+
+	r.definitionMu.Lock()
+	defer r.definitionMu.Unlock()
+	if r.resolved != nil && r.resolved.version == version {
+		return r.resolved, nil
+	}
+
+	def, err := r.catalog.GetByID(r.dataProductID)
+	if err != nil {
+		return nil, fmt.Errorf("could not find data product with id %v err=%v", r.dataProductID, err)
+	}
+	schemaDef, err := catalog.ToParquetSchema(*def)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.mapping.validate(def); err != nil {
+		return nil, err
+	}
+	hash := schemaHash(schemaDef)
+	if r.publishedHash != "" && hash != r.publishedHash {
+		return nil, fmt.Errorf("definition of data product %v changed after files of the run were published with schema hash %v, restart the run to publish it", r.dataProductID, r.publishedHash)
+	}
+	if r.guard != nil {
+		if err := r.guard.check(schemaDef, r.logger); err != nil {
+			return nil, fmt.Errorf("data product %v %v", r.dataProductID, err)
+		}
+	}
+
+	if r.resolved != nil {
+		r.logger.Infof("%v processor: definition of data product %v reloaded", r.format.name(), r.dataProductID)
+	}
+	r.resolved = &resolvedDefinition{
+		def:        def,
+		schemaDef:  schemaDef,
+		schemaHash: hash,
+		version:    version,
+	}
+	return r.resolved, nil
+}
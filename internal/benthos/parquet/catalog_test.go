@@ -0,0 +1,142 @@
+package parquet
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/benthosdev/benthos/v4/public/service"
+)
+
+func copyTestDefinitions(t *testing.T) string {
+	dir := t.TempDir()
+	b, err := os.ReadFile("../../../testassets/datadefinitions/sampdef.dd.yaml")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "sampdef.dd.yaml"), b, 0o644); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	return dir
+}
+
+func TestReloadingCatalog(t *testing.T) {
+	dir := copyTestDefinitions(t)
+	cat, err := NewReloadingCatalog(dir)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	conf, err := processorConfig().ParseYAML(`dataProductID: 75d44fdc-dffd-42ea-af06-06fa4cb6fdbd`, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	proc, err := newParquetProcessorFromConfig(cat, conf, service.MockResources().Logger())
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	resolved, err := proc.definition()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if resolved.def.DataProduct.FQN != "caps.v1.consent_and_preference" {
+		t.Fatalf("Unexpected fqn %v", resolved.def.DataProduct.FQN)
+	}
+
+	// Unchanged files don't reload the catalog.
+	if err := cat.reload(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if cat.Version() != 0 {
+		t.Fatalf("Expected catalog not to be reloaded")
+	}
+	if cached, _ := proc.definition(); cached != resolved {
+		t.Fatalf("Expected definition to be cached")
+	}
+
+	path := filepath.Join(dir, "sampdef.dd.yaml")
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	b = []byte(strings.Replace(string(b), "caps.v1.consent_and_preference", "caps.v2.consent_and_preference", 1))
+	if err := os.WriteFile(path, b, 0o644); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if err := cat.reload(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if cat.Version() != 1 {
+		t.Fatalf("Expected catalog to be reloaded")
+	}
+
+	resolved, err = proc.definition()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if resolved.def.DataProduct.FQN != "caps.v2.consent_and_preference" {
+		t.Fatalf("Expected reloaded fqn got %v", resolved.def.DataProduct.FQN)
+	}
+}
+
+func TestParquetProcessorUnknownDataProduct(t *testing.T) {
+	conf, err := processorConfig().ParseYAML(`dataProductID: 00000000-0000-0000-0000-000000000000`, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	cat, err := NewReloadingCatalog("../../../testassets/datadefinitions")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if _, err := newParquetProcessorFromConfig(cat, conf, service.MockResources().Logger()); err == nil {
+		t.Fatal("Expected error")
+	}
+}
+
+func TestReloadingCatalogAfterPublish(t *testing.T) {
+	dir := copyTestDefinitions(t)
+	cat, err := NewReloadingCatalog(dir)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	conf, err := processorConfig().ParseYAML(`dataProductID: 75d44fdc-dffd-42ea-af06-06fa4cb6fdbd`, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	proc, err := newParquetProcessorFromConfig(cat, conf, service.MockResources().Logger())
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	batch := service.MessageBatch{service.NewMessage([]byte(`{"citizen_id": "75d44fdc-dffd-42ea-af06-06fa4cb6fdbd"}`))}
+	if _, err := proc.ProcessBatch(context.Background(), batch); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	path := filepath.Join(dir, "sampdef.dd.yaml")
+	edit := func(old, new string) {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if err := os.WriteFile(path, []byte(strings.Replace(string(b), old, new, 1)), 0o644); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if err := cat.reload(); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	}
+
+	// Changes that keep the schema are picked up by the run.
+	edit("Did Citizen consented?", "Did the Citizen consent?")
+	if _, err := proc.ProcessBatch(context.Background(), batch); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	// The files of a run are never published under two schemas.
+	edit("type: BOOLEAN", "type: STRING")
+	if _, err := proc.ProcessBatch(context.Background(), batch); err == nil || !strings.Contains(err.Error(), "changed after files of the run were published") {
+		t.Fatalf("Expected definition change error got %v", err)
+	}
+}
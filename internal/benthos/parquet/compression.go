@@ -0,0 +1,204 @@
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	goparquet "github.com/fraugster/parquet-go"
+	"github.com/fraugster/parquet-go/parquet"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+var compressionCodecs = map[string]parquet.CompressionCodec{
+	"none":   parquet.CompressionCodec_UNCOMPRESSED,
+	"snappy": parquet.CompressionCodec_SNAPPY,
+	"gzip":   parquet.CompressionCodec_GZIP,
+	"zstd":   parquet.CompressionCodec_ZSTD,
+	"lz4":    parquet.CompressionCodec_LZ4_RAW,
+}
+
+// noCompressionLevel means the default level of a codec.
+const noCompressionLevel = -1
+
+var (
+	compressionLevels   = map[parquet.CompressionCodec]int{}
+	compressionLevelsMu sync.Mutex
+)
+
+// registerCompression registers the block compressor of a codec with the
+// given level. Block compressors are registered for the whole process, so
+// every processor using a codec must use the same level.
+func registerCompression(codec parquet.CompressionCodec, level int) error {
+	compressionLevelsMu.Lock()
+	defer compressionLevelsMu.Unlock()
+
+	if registered, ok := compressionLevels[codec]; ok {
+		if registered != level {
+			return fmt.Errorf("%v compression is already used with level %v, all processors must use the same level", codec, registered)
+		}
+		return nil
+	}
+
+	var compressor goparquet.BlockCompressor
+	switch codec {
+	case parquet.CompressionCodec_UNCOMPRESSED, parquet.CompressionCodec_SNAPPY:
+		if level != noCompressionLevel {
+			return fmt.Errorf("%v compression has no levels", codec)
+		}
+		compressionLevels[codec] = level
+		return nil
+	case parquet.CompressionCodec_GZIP:
+		if level == noCompressionLevel {
+			level = gzip.DefaultCompression
+		} else if level < gzip.BestSpeed || level > gzip.BestCompression {
+			return fmt.Errorf("gzip compression level must be between %v and %v, got %v", gzip.BestSpeed, gzip.BestCompression, level)
+		}
+		compressor = gzipCompressor{level: level}
+	case parquet.CompressionCodec_ZSTD:
+		c, err := newZstdCompressor(level)
+		if err != nil {
+			return err
+		}
+		compressor = c
+	case parquet.CompressionCodec_LZ4_RAW:
+		c, err := newLz4RawCompressor(level)
+		if err != nil {
+			return err
+		}
+		compressor = c
+	default:
+		return fmt.Errorf("unsupported compression %v", codec)
+	}
+
+	goparquet.RegisterBlockCompressor(codec, compressor)
+	compressionLevels[codec] = level
+	return nil
+}
+
+type gzipCompressor struct {
+	level int
+}
+
+func (c gzipCompressor) CompressBlock(block []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	w, err := gzip.NewWriterLevel(buf, c.level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(block); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c gzipCompressor) DecompressBlock(block []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(block))
+	if err != nil {
+		return nil, err
+	}
+	ret, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return ret, r.Close()
+}
+
+type zstdCompressor struct {
+	enc *zstd.Encoder
+	dec *zstd.Decoder
+}
+
+func newZstdCompressor(level int) (*zstdCompressor, error) {
+	opts := []zstd.EOption{}
+	if level != noCompressionLevel {
+		if level < 1 || level > 22 {
+			return nil, fmt.Errorf("zstd compression level must be between 1 and 22, got %v", level)
+		}
+		opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	}
+
+	enc, err := zstd.NewWriter(nil, opts...)
+	if err != nil {
+		return nil, err
+	}
+	dec, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, err
+	}
+	return &zstdCompressor{enc: enc, dec: dec}, nil
+}
+
+func (c *zstdCompressor) CompressBlock(block []byte) ([]byte, error) {
+	return c.enc.EncodeAll(block, nil), nil
+}
+
+func (c *zstdCompressor) DecompressBlock(block []byte) ([]byte, error) {
+	return c.dec.DecodeAll(block, nil)
+}
+
+var lz4Levels = []lz4.CompressionLevel{
+	lz4.Fast, lz4.Level1, lz4.Level2, lz4.Level3, lz4.Level4, lz4.Level5, lz4.Level6, lz4.Level7, lz4.Level8, lz4.Level9,
+}
+
+// lz4RawCompressor compresses blocks in the LZ4_RAW format, which has no
+// framing so the block size must be known up front.
+type lz4RawCompressor struct {
+	level lz4.CompressionLevel
+}
+
+func newLz4RawCompressor(level int) (*lz4RawCompressor, error) {
+	if level == noCompressionLevel {
+		level = 0
+	}
+	if level < 0 || level >= len(lz4Levels) {
+		return nil, fmt.Errorf("lz4 compression level must be between 0 and %v, got %v", len(lz4Levels)-1, level)
+	}
+	return &lz4RawCompressor{level: lz4Levels[level]}, nil
+}
+
+func (c *lz4RawCompressor) CompressBlock(block []byte) ([]byte, error) {
+	dst := make([]byte, lz4.CompressBlockBound(len(block)))
+
+	// Compressors aren't safe for concurrent use.
+	var n int
+	var err error
+	if c.level == lz4.Fast {
+		var compressor lz4.Compressor
+		n, err = compressor.CompressBlock(block, dst)
+	} else {
+		compressor := lz4.CompressorHC{Level: c.level}
+		n, err = compressor.CompressBlock(block, dst)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return dst[:n], nil
+}
+
+// DecompressBlock doesn't know the size of the decompressed block, so it grows
+// its buffer until the block fits. It is only used when reading files back.
+func (c *lz4RawCompressor) DecompressBlock(block []byte) ([]byte, error) {
+	size := len(block) * 4
+	if size < 64 {
+		size = 64
+	}
+	for {
+		dst := make([]byte, size)
+		n, err := lz4.UncompressBlock(block, dst)
+		if err == nil {
+			return dst[:n], nil
+		}
+		if !errors.Is(err, lz4.ErrInvalidSourceShortBuffer) || size > 1<<30 {
+			return nil, err
+		}
+		size *= 2
+	}
+}
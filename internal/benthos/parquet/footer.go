@@ -0,0 +1,441 @@
+package parquet
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	goparquet "github.com/fraugster/parquet-go"
+	"github.com/fraugster/parquet-go/parquet"
+)
+
+var parquetMagic = []byte("PAR1")
+
+// statsOptions are the statistics added to the footer of every file.
+type statsOptions struct {
+	// bloomFilterColumns are top level columns bloom filters are written for.
+	bloomFilterColumns []string
+	bloomFilterFPP     float64
+}
+
+// columnOrder is how the statistics the file writer computes for a column
+// relate to the type defined order of the column.
+type columnOrder int
+
+const (
+	// writerOrder statistics already follow the type defined order.
+	writerOrder columnOrder = iota
+	// booleanOrder columns have no statistics, the file writer doesn't
+	// compute them.
+	booleanOrder
+	// signedDecimalOrder columns are decimals stored as two's complement
+	// byte arrays, which the file writer compares as unsigned bytes.
+	signedDecimalOrder
+	// unsignedOrder columns are unsigned integers, which the file writer
+	// compares as signed ones.
+	unsignedOrder
+	// undefinedOrder columns (INT96) have no defined order, their min and
+	// max are dropped.
+	undefinedOrder
+)
+
+func orderOf(c *goparquet.Column) columnOrder {
+	e := c.Element()
+	switch {
+	case e.GetType() == parquet.Type_BOOLEAN:
+		return booleanOrder
+	case e.GetType() == parquet.Type_INT96:
+		return undefinedOrder
+	case isDecimal(e) && (e.GetType() == parquet.Type_BYTE_ARRAY || e.GetType() == parquet.Type_FIXED_LEN_BYTE_ARRAY):
+		return signedDecimalOrder
+	case logicalType(e).IsSetINTEGER() && !logicalType(e).INTEGER.GetIsSigned(),
+		hasConvertedType(e, parquet.ConvertedType_UINT_8, parquet.ConvertedType_UINT_16, parquet.ConvertedType_UINT_32, parquet.ConvertedType_UINT_64):
+		return unsignedOrder
+	}
+	return writerOrder
+}
+
+// collectedColumn is a column whose values are collected as rows are written,
+// either for its statistics or a bloom filter.
+type collectedColumn struct {
+	name  string
+	path  []string
+	typ   parquet.Type
+	order columnOrder
+	bloom bool
+}
+
+// chunkStats are the values collected from a column chunk.
+type chunkStats struct {
+	booleans booleanStats
+	min, max interface{}
+	hashes   []uint64
+	filter   *bloomFilter
+}
+
+type rowGroupStats struct {
+	rows   int64
+	chunks []chunkStats
+}
+
+// statsCollector collects the values of the columns of a file as its rows are
+// written, so that finishFile doesn't have to read the file back.
+type statsCollector struct {
+	opts       statsOptions
+	numColumns int
+	columns    []collectedColumn
+	current    rowGroupStats
+	groups     []rowGroupStats
+}
+
+// newCollector returns a collector of the statistics of a file with the given
+// columns.
+func (o statsOptions) newCollector(columns []*goparquet.Column) (*statsCollector, error) {
+	bloomColumns := map[string]bool{}
+	for _, name := range o.bloomFilterColumns {
+		bloomColumns[name] = false
+	}
+
+	c := &statsCollector{opts: o, numColumns: len(columns)}
+	for _, col := range columns {
+		name := strings.Join(col.Path(), ".")
+		_, bloom := bloomColumns[name]
+		if bloom {
+			if len(col.Path()) != 1 {
+				return nil, fmt.Errorf("bloom filter column %v is not a top level column of primitive type", name)
+			}
+			bloomColumns[name] = true
+		}
+		order := orderOf(col)
+		if order == writerOrder && !bloom {
+			continue
+		}
+		c.columns = append(c.columns, collectedColumn{
+			name:  name,
+			path:  col.Path(),
+			typ:   *col.Type(),
+			order: order,
+			bloom: bloom,
+		})
+	}
+	for name, found := range bloomColumns {
+		if !found {
+			return nil, fmt.Errorf("bloom filter column %v is not a top level column of primitive type", name)
+		}
+	}
+	c.current.chunks = make([]chunkStats, len(c.columns))
+	return c, nil
+}
+
+// add collects the values of a row written to the current row group.
+func (c *statsCollector) add(row map[string]interface{}) error {
+	c.current.rows++
+	for i := range c.columns {
+		col := &c.columns[i]
+		s := &c.current.chunks[i]
+		err := walkColumn(row, col.path, func(v interface{}) error {
+			switch col.order {
+			case booleanOrder:
+				s.booleans.add(v)
+			case signedDecimalOrder, unsignedOrder:
+				if err := s.addMinMax(col, v); err != nil {
+					return err
+				}
+			}
+			if col.bloom {
+				h, err := bloomHash(col.typ, v)
+				if err != nil {
+					return fmt.Errorf("column %v %v", col.name, err)
+				}
+				s.hashes = append(s.hashes, h)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flush ends the current row group, once the file writer has flushed it.
+func (c *statsCollector) flush() {
+	if c.current.rows == 0 {
+		return
+	}
+	for i, col := range c.columns {
+		s := &c.current.chunks[i]
+		if !col.bloom {
+			continue
+		}
+		s.filter = newBloomFilter(c.current.rows, c.opts.bloomFilterFPP)
+		for _, h := range s.hashes {
+			s.filter.insert(h)
+		}
+		s.hashes = nil
+	}
+	c.groups = append(c.groups, c.current)
+	c.current = rowGroupStats{chunks: make([]chunkStats, len(c.columns))}
+}
+
+// finishFile rewrites the footer of a closed parquet file. The file writer
+// computes the min and max of every column chunk but doesn't declare their sort
+// order, so most readers ignore them. The type defined order is declared for
+// every column after the statistics that don't follow it have been fixed: the
+// min and max of boolean columns are added, those of signed decimals and
+// unsigned integers recomputed and those of INT96 columns dropped. Bloom
+// filters are written for the configured columns.
+func (c *statsCollector) finishFile(file []byte) ([]byte, error) {
+	c.flush()
+
+	if len(file) < 12 || !bytes.Equal(file[len(file)-4:], parquetMagic) {
+		return nil, fmt.Errorf("invalid parquet file")
+	}
+	footerLen := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+	footerStart := len(file) - 8 - footerLen
+	if footerStart < len(parquetMagic) {
+		return nil, fmt.Errorf("invalid parquet footer length %v", footerLen)
+	}
+
+	meta := parquet.NewFileMetaData()
+	proto := thrift.NewTCompactProtocolConf(&thrift.StreamTransport{Reader: bytes.NewReader(file[footerStart : len(file)-8])}, &thrift.TConfiguration{})
+	if err := meta.Read(context.Background(), proto); err != nil {
+		return nil, fmt.Errorf("error reading parquet footer %v", err)
+	}
+	if len(meta.RowGroups) != len(c.groups) {
+		return nil, fmt.Errorf("parquet file has %v row groups, collected statistics of %v", len(meta.RowGroups), len(c.groups))
+	}
+
+	out := append([]byte(nil), file[:footerStart]...)
+	for i, rg := range meta.RowGroups {
+		g := c.groups[i]
+		if rg.NumRows != g.rows {
+			return nil, fmt.Errorf("row group %v has %v rows, collected statistics of %v", i, rg.NumRows, g.rows)
+		}
+		chunks := map[string]*parquet.ColumnChunk{}
+		for _, cc := range rg.Columns {
+			chunks[strings.Join(cc.MetaData.PathInSchema, ".")] = cc
+		}
+
+		for j, col := range c.columns {
+			cc, ok := chunks[col.name]
+			if !ok {
+				continue
+			}
+			s := g.chunks[j]
+			switch col.order {
+			case booleanOrder:
+				s.booleans.set(cc.MetaData)
+			case signedDecimalOrder, unsignedOrder:
+				if err := s.setMinMax(col, cc.MetaData); err != nil {
+					return nil, err
+				}
+			case undefinedOrder:
+				if stats := cc.MetaData.Statistics; stats != nil {
+					stats.MinValue, stats.MaxValue = nil, nil
+				}
+			}
+			if s.filter != nil {
+				offset := int64(len(out))
+				var err error
+				if out, err = appendThrift(out, s.filter.header()); err != nil {
+					return nil, err
+				}
+				out = append(out, s.filter.bitset()...)
+				cc.MetaData.BloomFilterOffset = &offset
+			}
+		}
+	}
+
+	meta.ColumnOrders = make([]*parquet.ColumnOrder, 0, c.numColumns)
+	for i := 0; i < c.numColumns; i++ {
+		meta.ColumnOrders = append(meta.ColumnOrders, &parquet.ColumnOrder{TYPE_ORDER: &parquet.TypeDefinedOrder{}})
+	}
+
+	footerStart = len(out)
+	var err error
+	if out, err = appendThrift(out, meta); err != nil {
+		return nil, err
+	}
+	footerLenBytes := make([]byte, 4)
+	binary.LittleEndian.PutUint32(footerLenBytes, uint32(len(out)-footerStart))
+	out = append(out, footerLenBytes...)
+	return append(out, parquetMagic...), nil
+}
+
+type thriftStruct interface {
+	Write(context.Context, thrift.TProtocol) error
+}
+
+func appendThrift(b []byte, s thriftStruct) ([]byte, error) {
+	buf := bytes.NewBuffer(b)
+	proto := thrift.NewTCompactProtocolConf(&thrift.StreamTransport{Writer: buf}, &thrift.TConfiguration{})
+	if err := s.Write(context.Background(), proto); err != nil {
+		return nil, err
+	}
+	if err := proto.Flush(context.Background()); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// walkColumn calls fn with every value of a column in a row, descending into
+// groups and repeated fields.
+func walkColumn(v interface{}, path []string, fn func(interface{}) error) error {
+	switch t := v.(type) {
+	case nil:
+		return nil
+	case map[string]interface{}:
+		if len(path) == 0 {
+			return fmt.Errorf("unexpected group value")
+		}
+		return walkColumn(t[path[0]], path[1:], fn)
+	case []map[string]interface{}:
+		for _, e := range t {
+			if err := walkColumn(e, path, fn); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if len(path) > 0 {
+		return fmt.Errorf("unexpected %T value of group %v", v, path[0])
+	}
+	// Repeated primitive values.
+	if s, ok := v.([]interface{}); ok {
+		for _, e := range s {
+			if err := fn(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fn(v)
+}
+
+// booleanStats are the min and max of a boolean column chunk, which the file
+// writer doesn't compute.
+type booleanStats struct {
+	hasFalse, hasTrue bool
+}
+
+func (s *booleanStats) add(v interface{}) {
+	if b, ok := v.(bool); ok {
+		s.hasFalse = s.hasFalse || !b
+		s.hasTrue = s.hasTrue || b
+	}
+}
+
+func (s *booleanStats) set(meta *parquet.ColumnMetaData) {
+	if !s.hasFalse && !s.hasTrue {
+		return
+	}
+	if meta.Statistics == nil {
+		meta.Statistics = parquet.NewStatistics()
+	}
+	min, max := []byte{1}, []byte{0}
+	if s.hasFalse {
+		min = []byte{0}
+	}
+	if s.hasTrue {
+		max = []byte{1}
+	}
+	meta.Statistics.MinValue = min
+	meta.Statistics.MaxValue = max
+}
+
+// addMinMax updates the min and max of a signed decimal or unsigned integer
+// column chunk.
+func (s *chunkStats) addMinMax(col *collectedColumn, v interface{}) error {
+	if v == nil {
+		return nil
+	}
+	if s.min == nil {
+		s.min, s.max = v, v
+		return nil
+	}
+	less, err := col.less(v, s.min)
+	if err != nil {
+		return err
+	}
+	if less {
+		s.min = v
+	}
+	if less, err = col.less(s.max, v); err != nil {
+		return err
+	}
+	if less {
+		s.max = v
+	}
+	return nil
+}
+
+func (s *chunkStats) setMinMax(col collectedColumn, meta *parquet.ColumnMetaData) error {
+	if meta.Statistics == nil {
+		meta.Statistics = parquet.NewStatistics()
+	}
+	if s.min == nil {
+		meta.Statistics.MinValue, meta.Statistics.MaxValue = nil, nil
+		return nil
+	}
+	min, err := col.encode(s.min)
+	if err != nil {
+		return err
+	}
+	max, err := col.encode(s.max)
+	if err != nil {
+		return err
+	}
+	meta.Statistics.MinValue = min
+	meta.Statistics.MaxValue = max
+	return nil
+}
+
+// less compares two values of a signed decimal or unsigned integer column in
+// their type defined order.
+func (col *collectedColumn) less(a, b interface{}) (bool, error) {
+	switch col.order {
+	case signedDecimalOrder:
+		x, ok := a.([]byte)
+		y, ok2 := b.([]byte)
+		if !ok || !ok2 {
+			return false, fmt.Errorf("unexpected %T value of decimal column %v", a, col.name)
+		}
+		return twosComplement(x).Cmp(twosComplement(y)) < 0, nil
+	case unsignedOrder:
+		switch x := a.(type) {
+		case int32:
+			y, ok := b.(int32)
+			if ok {
+				return uint32(x) < uint32(y), nil
+			}
+		case int64:
+			y, ok := b.(int64)
+			if ok {
+				return uint64(x) < uint64(y), nil
+			}
+		}
+		return false, fmt.Errorf("unexpected %T value of unsigned column %v", a, col.name)
+	}
+	return false, fmt.Errorf("column %v has no recomputed min and max", col.name)
+}
+
+// encode returns the plain encoding of a min or max value.
+func (col *collectedColumn) encode(v interface{}) ([]byte, error) {
+	switch t := v.(type) {
+	case []byte:
+		return t, nil
+	case int32:
+		b := make([]byte, 4)
+		binary.LittleEndian.PutUint32(b, uint32(t))
+		return b, nil
+	case int64:
+		b := make([]byte, 8)
+		binary.LittleEndian.PutUint64(b, uint64(t))
+		return b, nil
+	}
+	return nil, fmt.Errorf("unexpected %T value of column %v", v, col.name)
+}
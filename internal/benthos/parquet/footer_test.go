@@ -0,0 +1,230 @@
+package parquet
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	goparquet "github.com/fraugster/parquet-go"
+	"github.com/fraugster/parquet-go/parquet"
+	"github.com/fraugster/parquet-go/parquetschema"
+)
+
+func readFooter(t *testing.T, file []byte) *parquet.FileMetaData {
+	footerLen := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+	meta := parquet.NewFileMetaData()
+	proto := thrift.NewTCompactProtocolConf(&thrift.StreamTransport{Reader: bytes.NewReader(file[len(file)-8-footerLen : len(file)-8])}, &thrift.TConfiguration{})
+	if err := meta.Read(context.Background(), proto); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	return meta
+}
+
+func readBloomFilter(t *testing.T, file []byte, offset int64) *bloomFilter {
+	header := parquet.NewBloomFilterHeader()
+	r := bytes.NewReader(file[offset:])
+	proto := thrift.NewTCompactProtocolConf(&thrift.StreamTransport{Reader: r}, &thrift.TConfiguration{})
+	if err := header.Read(context.Background(), proto); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if header.Algorithm.BLOCK == nil || header.Hash.XXHASH == nil || header.Compression.UNCOMPRESSED == nil {
+		t.Fatalf("Unexpected bloom filter header %v", header)
+	}
+
+	start := offset + int64(len(file[offset:])-r.Len())
+	bitset := file[start : start+int64(header.NumBytes)]
+	f := &bloomFilter{blocks: make([][8]uint32, len(bitset)/bloomBlockBytes)}
+	for i := range f.blocks {
+		for j := range f.blocks[i] {
+			f.blocks[i][j] = binary.LittleEndian.Uint32(bitset[i*bloomBlockBytes+j*4:])
+		}
+	}
+	return f
+}
+
+func TestFinishFile(t *testing.T) {
+	schemaDef, err := parquetschema.ParseSchemaDefinition(`message test {
+		required binary id (STRING);
+		required int64 n;
+		optional boolean flag;
+		optional group items (LIST) {
+			repeated group list {
+				required group element {
+					optional boolean ok;
+				}
+			}
+		}
+	}`)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	opts := testWriterOptions()
+	opts.rowGroupSize = 512
+	var files [][]byte
+	w := newRollingWriter(0, 0, nil, func(w io.Writer, extra ...goparquet.FileWriterOption) (*goparquet.FileWriter, error) {
+		return opts.newFileWriter(w, schemaDef, extra...)
+	}, func(f []byte) error { files = append(files, f); return nil })
+	w.stats = &statsOptions{bloomFilterColumns: []string{"id", "n"}, bloomFilterFPP: 0.01}
+
+	for i := 0; i < 200; i++ {
+		row := map[string]interface{}{
+			"id": []byte(fmt.Sprintf("id-%v", i)),
+			"n":  int64(i),
+			"items": map[string]interface{}{
+				"list": []map[string]interface{}{
+					{"element": map[string]interface{}{"ok": true}},
+				},
+			},
+		}
+		if i%2 == 0 {
+			row["flag"] = true
+		}
+		if err := w.add(row); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	}
+	if err := w.close(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	file := files[0]
+
+	// The file is still readable.
+	fr, err := goparquet.NewFileReader(bytes.NewReader(file))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if fr.NumRows() != 200 {
+		t.Fatalf("Expected 200 rows got %v", fr.NumRows())
+	}
+	for i := 0; i < 200; i++ {
+		row, err := fr.NextRow()
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if row["n"] != int64(i) {
+			t.Fatalf("Unexpected row %v", row)
+		}
+	}
+
+	meta := readFooter(t, file)
+	if len(meta.ColumnOrders) != 4 {
+		t.Fatalf("Expected 4 column orders got %v", len(meta.ColumnOrders))
+	}
+	if len(meta.RowGroups) < 2 {
+		t.Fatalf("Expected several row groups got %v", len(meta.RowGroups))
+	}
+
+	first := int64(0)
+	for _, rg := range meta.RowGroups {
+		cols := rg.Columns
+		var nulls int64
+		for i := first; i < first+rg.NumRows; i++ {
+			if i%2 != 0 {
+				nulls++
+			}
+		}
+		if stats := cols[2].MetaData.Statistics; !bytes.Equal(stats.MinValue, []byte{1}) || !bytes.Equal(stats.MaxValue, []byte{1}) || stats.GetNullCount() != nulls {
+			t.Fatalf("Unexpected flag statistics %v", stats)
+		}
+		if stats := cols[3].MetaData.Statistics; !bytes.Equal(stats.MinValue, []byte{1}) || !bytes.Equal(stats.MaxValue, []byte{1}) {
+			t.Fatalf("Unexpected items statistics %v", stats)
+		}
+		if cols[2].MetaData.BloomFilterOffset != nil {
+			t.Fatal("Expected no bloom filter for flag")
+		}
+
+		ids := readBloomFilter(t, file, cols[0].MetaData.GetBloomFilterOffset())
+		ns := readBloomFilter(t, file, cols[1].MetaData.GetBloomFilterOffset())
+		for i := first; i < first+rg.NumRows; i++ {
+			h, _ := bloomHash(parquet.Type_BYTE_ARRAY, []byte(fmt.Sprintf("id-%v", i)))
+			if !ids.check(h) {
+				t.Fatalf("Expected id-%v in bloom filter", i)
+			}
+			h, _ = bloomHash(parquet.Type_INT64, i)
+			if !ns.check(h) {
+				t.Fatalf("Expected %v in bloom filter", i)
+			}
+		}
+		first += rg.NumRows
+	}
+}
+
+func TestFinishFileSignedStatistics(t *testing.T) {
+	schemaDef, err := parquetschema.ParseSchemaDefinition(`message test {
+		required fixed_len_byte_array(4) amount (DECIMAL(9, 2));
+		optional binary balance (DECIMAL(20, 2));
+		required int32 count (INT(32, false));
+		optional int96 created_at;
+	}`)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	opts := testWriterOptions()
+	var files [][]byte
+	w := newRollingWriter(0, 0, nil, func(w io.Writer, extra ...goparquet.FileWriterOption) (*goparquet.FileWriter, error) {
+		return opts.newFileWriter(w, schemaDef, extra...)
+	}, func(f []byte) error { files = append(files, f); return nil })
+	w.stats = &statsOptions{bloomFilterFPP: 0.01}
+
+	fixed := func(i int32) []byte {
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, uint32(i))
+		return b
+	}
+	for _, row := range []map[string]interface{}{
+		{"amount": fixed(-250), "balance": []byte{0xff, 0x38}, "count": int32(1), "created_at": [12]byte{1}},
+		{"amount": fixed(100), "balance": []byte{0x01, 0x00}, "count": int32(-1)},
+		{"amount": fixed(-1), "balance": []byte{0x7f}, "count": int32(7)},
+		{"amount": fixed(0), "count": int32(0)},
+	} {
+		if err := w.add(row); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	}
+	if err := w.close(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	meta := readFooter(t, files[0])
+	if len(meta.ColumnOrders) != 4 {
+		t.Fatalf("Expected 4 column orders got %v", len(meta.ColumnOrders))
+	}
+	cols := meta.RowGroups[0].Columns
+	if stats := cols[0].MetaData.Statistics; !bytes.Equal(stats.MinValue, fixed(-250)) || !bytes.Equal(stats.MaxValue, fixed(100)) {
+		t.Fatalf("Unexpected amount statistics %v", stats)
+	}
+	// -200, 256 and 127.
+	if stats := cols[1].MetaData.Statistics; !bytes.Equal(stats.MinValue, []byte{0xff, 0x38}) || !bytes.Equal(stats.MaxValue, []byte{0x01, 0x00}) || stats.GetNullCount() != 1 {
+		t.Fatalf("Unexpected balance statistics %v", stats)
+	}
+	if stats := cols[2].MetaData.Statistics; !bytes.Equal(stats.MinValue, []byte{0, 0, 0, 0}) || !bytes.Equal(stats.MaxValue, []byte{0xff, 0xff, 0xff, 0xff}) {
+		t.Fatalf("Unexpected count statistics %v", stats)
+	}
+	if stats := cols[3].MetaData.Statistics; stats.MinValue != nil || stats.MaxValue != nil || stats.GetNullCount() != 3 {
+		t.Fatalf("Unexpected created_at statistics %v", stats)
+	}
+}
+
+func TestBloomFilterFalsePositives(t *testing.T) {
+	f := newBloomFilter(10000, 0.01)
+	for i := int64(0); i < 10000; i++ {
+		h, _ := bloomHash(parquet.Type_INT64, i)
+		f.insert(h)
+	}
+	falsePositives := 0
+	for i := int64(10000); i < 20000; i++ {
+		h, _ := bloomHash(parquet.Type_INT64, i)
+		if f.check(h) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 200 {
+		t.Fatalf("Expected about 1%% false positives got %v", falsePositives)
+	}
+}
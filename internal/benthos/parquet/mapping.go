@@ -0,0 +1,147 @@
+package parquet
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/benthosdev/benthos/v4/public/service"
+	"github.com/utilitywarehouse/data-infra-pg-source/internal/benthos/dataproduct"
+	"github.com/utilitywarehouse/data-products-definitions/pkg/catalog/v1"
+)
+
+func mappingField() *service.ConfigField {
+	return dataproduct.MappingField()
+}
+
+type dataPointMapping struct {
+	dataPoint string
+	column    string
+	path      []string
+	// defaultValue is set if hasDefault.
+	defaultValue interface{}
+	hasDefault   bool
+}
+
+// columnMapping maps the columns of rows to data points before they are
+// validated.
+type columnMapping struct {
+	dataPoints []dataPointMapping
+}
+
+func columnMappingFromParsed(confs []*service.ParsedConfig) (*columnMapping, error) {
+	mappings, err := dataproduct.MappingsFromParsed(confs)
+	if err != nil {
+		return nil, err
+	}
+	if len(mappings) == 0 {
+		return nil, nil
+	}
+	m := &columnMapping{}
+	for _, mapping := range mappings {
+		dp := dataPointMapping{
+			dataPoint:  mapping.DataPoint,
+			column:     mapping.Column,
+			path:       mapping.Path,
+			hasDefault: mapping.HasDefault,
+		}
+		if dp.hasDefault {
+			if err := json.Unmarshal([]byte(mapping.Default), &dp.defaultValue); err != nil {
+				return nil, fmt.Errorf("default of data point %v must be JSON encoded err=%v", dp.dataPoint, err)
+			}
+		}
+		m.dataPoints = append(m.dataPoints, dp)
+	}
+	return m, nil
+}
+
+// validate checks that every mapped data point is defined.
+func (m *columnMapping) validate(def *catalog.Definition) error {
+	if m == nil {
+		return nil
+	}
+	defined := map[string]bool{}
+	for _, dp := range def.DataProduct.DataPoints {
+		defined[dp.Name] = true
+	}
+	for _, dp := range m.dataPoints {
+		if !defined[dp.dataPoint] {
+			return fmt.Errorf("mapped data point %v is not defined by data product %v", dp.dataPoint, def.DataProduct.ID)
+		}
+	}
+	return nil
+}
+
+// apply returns the row with the mapped data points set. Each json column is
+// only decoded once, however many data points are mapped to its values.
+func (m *columnMapping) apply(row interface{}) (interface{}, error) {
+	p, ok := row.(map[string]interface{})
+	if m == nil || !ok {
+		return row, nil
+	}
+
+	mapped := make(map[string]interface{}, len(p)+len(m.dataPoints))
+	for k, v := range p {
+		mapped[k] = v
+	}
+	var decoded map[string]interface{}
+	for _, dp := range m.dataPoints {
+		v := p[dp.column]
+		if len(dp.path) > 0 && v != nil {
+			doc, ok := decoded[dp.column]
+			if !ok {
+				var err error
+				if doc, err = decodeJSON(v); err != nil {
+					return nil, &invalidRowError{dataPoint: dp.dataPoint, err: fmt.Errorf("column %v %v", dp.column, err)}
+				}
+				if decoded == nil {
+					decoded = map[string]interface{}{}
+				}
+				decoded[dp.column] = doc
+			}
+			v = extractPath(doc, dp.path)
+		}
+		if v == nil && dp.hasDefault {
+			v = dp.defaultValue
+		}
+		mapped[dp.dataPoint] = v
+	}
+	return mapped, nil
+}
+
+// decodeJSON decodes the value of a json column, values that have already
+// been decoded are returned as is.
+func decodeJSON(v interface{}) (interface{}, error) {
+	switch t := v.(type) {
+	case string:
+		if err := json.Unmarshal([]byte(t), &v); err != nil {
+			return nil, errors.New("should be of type json")
+		}
+	case []byte:
+		if err := json.Unmarshal(t, &v); err != nil {
+			return nil, errors.New("should be of type json")
+		}
+	}
+	return v, nil
+}
+
+// extractPath returns the value at a path inside a decoded json value, or nil
+// if the path doesn't exist.
+func extractPath(v interface{}, path []string) interface{} {
+	for _, key := range path {
+		switch t := v.(type) {
+		case map[string]interface{}:
+			v = t[key]
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(t) {
+				return nil
+			}
+			v = t[i]
+		default:
+			return nil
+		}
+	}
+	return v
+}
@@ -0,0 +1,237 @@
+package parquet
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/benthosdev/benthos/v4/public/service"
+	goparquet "github.com/fraugster/parquet-go"
+	"github.com/utilitywarehouse/data-infra-pg-source/internal/benthos/dataproduct"
+	"github.com/utilitywarehouse/data-products-definitions/pkg/catalog/v1"
+)
+
+func TestColumnMappingApply(t *testing.T) {
+	conf, err := processorConfig().ParseYAML(`
+dataProductID: 75d44fdc-dffd-42ea-af06-06fa4cb6fdbd
+mapping:
+  - data_point: citizen_id
+    column: legacy_id
+  - data_point: email
+    column: profile
+    path: contact.email
+    default: '"unknown"'
+  - data_point: postcode
+    column: profile
+    path: addresses.1.postcode
+  - data_point: contact
+    column: profile
+    path: contact
+`, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	confs, err := conf.FieldObjectList("mapping")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	m, err := columnMappingFromParsed(confs)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	for _, tc := range []struct {
+		row      map[string]interface{}
+		expected map[string]interface{}
+	}{
+		{
+			row: map[string]interface{}{
+				"legacy_id": expectedCitizenID,
+				"profile":   `{"contact": {"email": "a@b.c"}, "addresses": [{"postcode": "N1"}, {"postcode": "E1"}]}`,
+			},
+			expected: map[string]interface{}{
+				"legacy_id":  expectedCitizenID,
+				"profile":    `{"contact": {"email": "a@b.c"}, "addresses": [{"postcode": "N1"}, {"postcode": "E1"}]}`,
+				"citizen_id": expectedCitizenID,
+				"email":      "a@b.c",
+				"postcode":   "E1",
+				"contact":    map[string]interface{}{"email": "a@b.c"},
+			},
+		},
+		{
+			row: map[string]interface{}{"profile": `{"addresses": [{"postcode": "N1"}]}`},
+			expected: map[string]interface{}{
+				"profile":    `{"addresses": [{"postcode": "N1"}]}`,
+				"citizen_id": nil,
+				"email":      "unknown",
+				"postcode":   nil,
+				"contact":    nil,
+			},
+		},
+		{
+			row: map[string]interface{}{"profile": nil},
+			expected: map[string]interface{}{
+				"profile":    nil,
+				"citizen_id": nil,
+				"email":      "unknown",
+				"postcode":   nil,
+				"contact":    nil,
+			},
+		},
+	} {
+		mapped, err := m.apply(tc.row)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if !reflect.DeepEqual(mapped, tc.expected) {
+			t.Fatalf("Expected %v got %v", tc.expected, mapped)
+		}
+	}
+
+	_, err = m.apply(map[string]interface{}{"profile": "not json"})
+	if err == nil || !strings.Contains(err.Error(), "email") {
+		t.Fatalf("Expected error of data point email, got %v", err)
+	}
+}
+
+func TestParquetProcessorMapping(t *testing.T) {
+	conf, err := processorConfig().ParseYAML(`
+dataProductID: 75d44fdc-dffd-42ea-af06-06fa4cb6fdbd
+mapping:
+  - data_point: citizen_id
+    column: profile
+    path: ids.citizen
+  - data_point: consent_references
+    column: profile
+    path: consents
+`, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	proc, err := newParquetProcessorFromConfig(catalog.New("../../../testassets/datadefinitions"), conf, service.MockResources().Logger())
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	result, err := proc.ProcessBatch(context.Background(), service.MessageBatch{
+		service.NewMessage([]byte(`{"profile": "{\"ids\": {\"citizen\": \"` + expectedCitizenID + `\"}, \"consents\": [{\"consent_reference\": \"email-insurance\", \"is_consented\": true}]}"}`)),
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(result) != 1 || len(result[0]) != 1 {
+		t.Fatalf("Expected a batch of 1 file")
+	}
+}
+
+func TestParquetProcessorMappingUnknownDataPoint(t *testing.T) {
+	conf, err := processorConfig().ParseYAML(`
+dataProductID: 75d44fdc-dffd-42ea-af06-06fa4cb6fdbd
+mapping:
+  - data_point: unknown
+    column: legacy_id
+`, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if _, err := newParquetProcessorFromConfig(catalog.New("../../../testassets/datadefinitions"), conf, service.MockResources().Logger()); err == nil {
+		t.Fatal("Expected error")
+	}
+}
+
+func TestMappingQueryPreflightAndProcessor(t *testing.T) {
+	conf, err := processorConfig().ParseYAML(`
+dataProductID: 75d44fdc-dffd-42ea-af06-06fa4cb6fdbd
+mapping:
+  - data_point: citizen_id
+    column: profile
+    path: ids.citizen
+  - data_point: consent_references
+    column: legacy_consents
+`, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	confs, err := conf.FieldObjectList("mapping")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	mapping, err := dataproduct.MappingsFromParsed(confs)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	cat := catalog.New("../../../testassets/datadefinitions")
+	def, err := cat.GetByID("75d44fdc-dffd-42ea-af06-06fa4cb6fdbd")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	// The query selects the source columns.
+	query, err := SelectQuery(def, "public.consents", mapping)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	expected := `SELECT "profile", "legacy_consents" FROM "public"."consents"`
+	if query != expected {
+		t.Fatalf("Expected %v got %v", expected, query)
+	}
+
+	// The preflight checks the source columns.
+	nullable := true
+	if err := CheckColumns(def, []dataproduct.Column{
+		{Name: "profile", Type: "jsonb", Nullable: &nullable},
+		{Name: "legacy_consents", Type: "jsonb"},
+	}, mapping); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	err = CheckColumns(def, []dataproduct.Column{
+		{Name: "citizen_id", Type: "text"},
+		{Name: "profile", Type: "text"},
+	}, mapping)
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+	for _, problem := range []string{
+		"citizen_id: expected json or jsonb column profile, got text",
+		"consent_references: missing column legacy_consents",
+	} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Fatalf("Expected %q in %v", problem, err)
+		}
+	}
+
+	// The processor maps the rows of the query.
+	proc, err := newParquetProcessorFromConfig(cat, conf, service.MockResources().Logger())
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	result, err := proc.ProcessBatch(context.Background(), service.MessageBatch{
+		service.NewMessage([]byte(`{"profile": "{\"ids\": {\"citizen\": \"` + expectedCitizenID + `\"}}", "legacy_consents": "[{\"consent_reference\": \"email-insurance\", \"is_consented\": true}]"}`)),
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(result) != 1 || len(result[0]) != 1 {
+		t.Fatalf("Expected a batch of 1 file")
+	}
+	file, err := result[0][0].AsBytes()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	fr, err := goparquet.NewFileReader(bytes.NewReader(file))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	row, err := fr.NextRow()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if id, _ := row["citizen_id"].([]byte); string(id) != expectedCitizenID {
+		t.Fatalf("Expected citizen_id %v got %v", expectedCitizenID, row["citizen_id"])
+	}
+	if row["consent_references"] == nil {
+		t.Fatalf("Expected consent_references got %v", row)
+	}
+}
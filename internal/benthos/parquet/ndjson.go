@@ -0,0 +1,203 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/benthosdev/benthos/v4/public/service"
+	"github.com/fraugster/parquet-go/parquet"
+	"github.com/fraugster/parquet-go/parquetschema"
+)
+
+func ndjsonProcessorConfig() *service.ConfigSpec {
+	spec := service.NewConfigSpec().
+		Summary("Processor for generating newline delimited JSON files using sql_raw input.").
+		Description("Rows are validated against the definition of the data product like `uw_parquet` does and written as one JSON object per line, with the types of its parquet schema: timestamps are RFC 3339 strings, dates are `YYYY-MM-DD` strings and decimals are numbers.").
+		Field(dataProductIDField()).
+		Field(service.NewIntField("max_file_rows").
+			Description("The maximum number of rows written to a file before a new one is started. If value <= 0, files are not limited by rows.").
+			Default(0).
+			Example(1000000)).
+		Field(service.NewIntField("max_file_size").
+			Description("The approximate maximum size in bytes of a file before a new one is started, a file is closed once the line that reaches it is written. If value <= 0, files are not limited by size.").
+			Default(0).
+			Example(64 * 1024 * 1024))
+	return withProcessingFields(spec)
+}
+
+func newNDJSONProcessorFromConfig(cat Definitions, conf *service.ParsedConfig, logger *service.Logger) (*dataProductProcessor, error) {
+	f := &ndjsonFormat{}
+	maxFileRows, err := conf.FieldInt("max_file_rows")
+	if err != nil {
+		return nil, err
+	}
+	f.maxFileRows = int64(maxFileRows)
+
+	maxFileSize, err := conf.FieldInt("max_file_size")
+	if err != nil {
+		return nil, err
+	}
+	f.maxFileSize = int64(maxFileSize)
+
+	r, err := newDataProductProcessorFromConfig(cat, conf, f, logger)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.definition(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// ndjsonFormat writes rows to newline delimited JSON files.
+type ndjsonFormat struct {
+	maxFileRows int64
+	maxFileSize int64
+}
+
+func (f *ndjsonFormat) name() string {
+	return "NDJSON"
+}
+
+func (f *ndjsonFormat) newEncoder(resolved *resolvedDefinition, provenance Provenance, emit func([]byte) error) (rowEncoder, error) {
+	return &ndjsonEncoder{root: resolved.schemaDef.RootColumn, maxRows: f.maxFileRows, maxBytes: f.maxFileSize, emit: emit}, nil
+}
+
+type ndjsonEncoder struct {
+	root     *parquetschema.ColumnDefinition
+	maxRows  int64
+	maxBytes int64
+	emit     func([]byte) error
+
+	buf  bytes.Buffer
+	rows int64
+}
+
+func (e *ndjsonEncoder) add(row map[string]interface{}) error {
+	v, err := jsonValue(e.root, row)
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	e.buf.Write(line)
+	e.buf.WriteByte('\n')
+	e.rows++
+	if e.maxRows > 0 && e.rows >= e.maxRows || e.maxBytes > 0 && int64(e.buf.Len()) >= e.maxBytes {
+		return e.roll()
+	}
+	return nil
+}
+
+func (e *ndjsonEncoder) roll() error {
+	file := append([]byte(nil), e.buf.Bytes()...)
+	e.buf.Reset()
+	e.rows = 0
+	return e.emit(file)
+}
+
+func (e *ndjsonEncoder) close() error {
+	if e.rows > 0 {
+		return e.roll()
+	}
+	return nil
+}
+
+// jsonValue converts the value of a column to a value encoding/json marshals
+// to its logical type.
+func jsonValue(c *parquetschema.ColumnDefinition, v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	if c.SchemaElement.GetRepetitionType() == parquet.FieldRepetitionType_REPEATED {
+		values, err := repeatedValues(v)
+		if err != nil {
+			return nil, err
+		}
+		out := make([]interface{}, len(values))
+		for i, e := range values {
+			if out[i], err = jsonElement(c, e); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	}
+	return jsonElement(c, v)
+}
+
+// jsonElement converts a single value of a column, regardless of its
+// repetition.
+func jsonElement(c *parquetschema.ColumnDefinition, v interface{}) (interface{}, error) {
+	if len(c.Children) == 0 {
+		return jsonPrimitive(c.SchemaElement, v)
+	}
+
+	switch kindOfGroup(c) {
+	case listGroup:
+		entries, err := groupEntries(c, v)
+		if err != nil {
+			return nil, err
+		}
+		element := c.Children[0].Children[0]
+		out := make([]interface{}, len(entries))
+		for i, e := range entries {
+			if out[i], err = jsonValue(element, e[element.SchemaElement.GetName()]); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	case mapGroup:
+		entries, err := groupEntries(c, v)
+		if err != nil {
+			return nil, err
+		}
+		key, value := c.Children[0].Children[0], c.Children[0].Children[1]
+		out := make(map[string]interface{}, len(entries))
+		for _, e := range entries {
+			k, err := jsonPrimitive(key.SchemaElement, e[key.SchemaElement.GetName()])
+			if err != nil {
+				return nil, err
+			}
+			if out[fmt.Sprint(k)], err = jsonValue(value, e[value.SchemaElement.GetName()]); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	}
+
+	group, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected %T value of group %v", v, c.SchemaElement.GetName())
+	}
+	out := make(map[string]interface{}, len(c.Children))
+	for _, child := range c.Children {
+		name := child.SchemaElement.GetName()
+		var err error
+		if out[name], err = jsonValue(child, group[name]); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func jsonPrimitive(e *parquet.SchemaElement, v interface{}) (interface{}, error) {
+	l, err := logicalValue(e, v)
+	if err != nil {
+		return nil, err
+	}
+	switch t := l.(type) {
+	case time.Time:
+		if isDate(e) {
+			return t.Format("2006-01-02"), nil
+		}
+		return t.Format(time.RFC3339Nano), nil
+	case *big.Rat:
+		return json.Number(t.FloatString(int(decimalScale(e)))), nil
+	}
+	return l, nil
+}
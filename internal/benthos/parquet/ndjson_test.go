@@ -0,0 +1,131 @@
+package parquet
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/benthosdev/benthos/v4/public/service"
+	"github.com/fraugster/parquet-go/parquetschema"
+	"github.com/utilitywarehouse/data-products-definitions/pkg/catalog/v1"
+)
+
+func TestJSONValue(t *testing.T) {
+	schemaDef, err := parquetschema.ParseSchemaDefinition(testLogicalSchema)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	v, err := jsonValue(schemaDef.RootColumn, testLogicalRow())
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	expected := `{"address":{"city":"London","verified":null},"amount":123.45,"attributes":{"k":1},"birthday":"2000-01-01","created_at":"2020-09-13T12:26:40Z","id":"a","tags":["x","y"]}`
+	if string(b) != expected {
+		t.Fatalf("Expected %v got %v", expected, string(b))
+	}
+}
+
+func TestNDJSONProcessor(t *testing.T) {
+	conf, err := ndjsonProcessorConfig().ParseYAML(`
+dataProductID: 75d44fdc-dffd-42ea-af06-06fa4cb6fdbd
+max_file_rows: 2
+`, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	proc, err := newNDJSONProcessorFromConfig(catalog.New("../../../testassets/datadefinitions"), conf, service.MockResources().Logger())
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	batch := service.MessageBatch{}
+	for i := 0; i < 3; i++ {
+		batch = append(batch, service.NewMessage([]byte(fmt.Sprintf(`{"citizen_id": "%s"}`, expectedCitizenID))))
+	}
+	result, err := proc.ProcessBatch(context.Background(), batch)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(result) != 1 || len(result[0]) != 2 {
+		t.Fatalf("Expected a batch of 2 files")
+	}
+
+	for i, expectedRows := range []int{2, 1} {
+		payload, err := result[0][i].AsBytes()
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		lines := strings.Split(strings.TrimSuffix(string(payload), "\n"), "\n")
+		if len(lines) != expectedRows {
+			t.Fatalf("Expected file %v to have %v rows got %v", i, expectedRows, len(lines))
+		}
+		var row map[string]interface{}
+		if err := json.Unmarshal([]byte(lines[0]), &row); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if row["citizen_id"] != expectedCitizenID {
+			t.Fatalf("Expected %s got %s", expectedCitizenID, row["citizen_id"])
+		}
+	}
+}
+
+func TestNDJSONProcessorRollsBySize(t *testing.T) {
+	process := func(maxFileSize int) service.MessageBatch {
+		conf, err := ndjsonProcessorConfig().ParseYAML(fmt.Sprintf("dataProductID: 75d44fdc-dffd-42ea-af06-06fa4cb6fdbd\nmax_file_size: %v", maxFileSize), nil)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		proc, err := newNDJSONProcessorFromConfig(catalog.New("../../../testassets/datadefinitions"), conf, service.MockResources().Logger())
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		batch := service.MessageBatch{}
+		for i := 0; i < 3; i++ {
+			batch = append(batch, service.NewMessage([]byte(fmt.Sprintf(`{"citizen_id": "%s"}`, expectedCitizenID))))
+		}
+		result, err := proc.ProcessBatch(context.Background(), batch)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		return result[0]
+	}
+
+	files := process(0)
+	if len(files) != 1 {
+		t.Fatalf("Expected a single file got %v", len(files))
+	}
+	payload, err := files[0].AsBytes()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	line := len(payload) / 3
+
+	// A file is closed once the line reaching the size is written.
+	for maxFileSize, expectedRows := range map[int][]int{
+		1:          {1, 1, 1},
+		2 * line:   {2, 1},
+		2*line + 1: {3},
+		10 * line:  {3},
+	} {
+		files := process(maxFileSize)
+		if len(files) != len(expectedRows) {
+			t.Fatalf("max_file_size %v: expected %v files got %v", maxFileSize, len(expectedRows), len(files))
+		}
+		for i, rows := range expectedRows {
+			payload, err := files[i].AsBytes()
+			if err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			if lines := strings.Count(string(payload), "\n"); lines != rows {
+				t.Fatalf("max_file_size %v: expected file %v to have %v rows got %v", maxFileSize, i, rows, lines)
+			}
+		}
+	}
+}
@@ -0,0 +1,325 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/fraugster/parquet-go/parquet"
+	"github.com/fraugster/parquet-go/parquetschema"
+	"github.com/google/uuid"
+)
+
+// nestedValue returns the value of a nested data point as json would be
+// decoded. Text, whether a string, bytes or json.RawMessage, is decoded with
+// decodeNested, while values that are already decoded, e.g. by another input
+// or the driver, are normalised through json with their numbers kept exact.
+func nestedValue(c *parquetschema.SchemaDefinition, isArray bool, v interface{}) (interface{}, error) {
+	switch t := v.(type) {
+	case string:
+		return decodeNested(c, isArray, t)
+	case []byte:
+		return decodeNested(c, isArray, string(t))
+	case json.RawMessage:
+		return decodeNested(c, isArray, string(t))
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("nested data point of type %T can't be decoded as json", v)
+	}
+	return decodeNestedJSON(c, b)
+}
+
+// decodeNestedJSON decodes the json of a nested data point following its column:
+// integers and decimals are json.Number so that their digits are kept, other
+// numbers float64 as json would decode them.
+func decodeNestedJSON(c *parquetschema.SchemaDefinition, b []byte) (interface{}, error) {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var nested interface{}
+	if err := d.Decode(&nested); err != nil {
+		return nil, err
+	}
+	if _, err := d.Token(); err != io.EOF {
+		return nil, errors.New("unexpected data after json value")
+	}
+	var column *parquetschema.ColumnDefinition
+	if c != nil {
+		column = c.RootColumn
+	}
+	return columnNumbers(column, nested), nil
+}
+
+// columnNumbers walks a value decoded with json.Number along its column, nil
+// if unknown, and turns the numbers of columns other than integers and
+// decimals into float64.
+func columnNumbers(c *parquetschema.ColumnDefinition, v interface{}) interface{} {
+	switch t := v.(type) {
+	case json.Number:
+		if c != nil && len(c.Children) == 0 && (isDecimal(c.SchemaElement) || isInteger(c.SchemaElement) && validInteger(t)) {
+			return t
+		}
+		f, _ := t.Float64()
+		return f
+	case []interface{}:
+		var element *parquetschema.ColumnDefinition
+		if c != nil && len(c.Children) > 0 && kindOfGroup(c) == listGroup {
+			element = c.Children[0].Children[0]
+		}
+		for i, e := range t {
+			t[i] = columnNumbers(element, e)
+		}
+	case map[string]interface{}:
+		for k, e := range t {
+			t[k] = columnNumbers(childColumn(c, k), e)
+		}
+	}
+	return v
+}
+
+// childColumn returns the child column of a record column by name, or nil.
+func childColumn(c *parquetschema.ColumnDefinition, name string) *parquetschema.ColumnDefinition {
+	if c == nil || len(c.Children) == 0 || kindOfGroup(c) != recordGroup {
+		return nil
+	}
+	for _, child := range c.Children {
+		if child.SchemaElement.GetName() == name {
+			return child
+		}
+	}
+	return nil
+}
+
+func validInteger(n json.Number) bool {
+	_, err := n.Int64()
+	return err == nil
+}
+
+// floatNumbers returns a copy of a nested value with its integers and decimals
+// as float64, as json would decode them.
+func floatNumbers(v interface{}) interface{} {
+	switch t := v.(type) {
+	case json.Number:
+		f, _ := t.Float64()
+		return f
+	case []interface{}:
+		values := make([]interface{}, len(t))
+		for i, e := range t {
+			values[i] = floatNumbers(e)
+		}
+		return values
+	case map[string]interface{}:
+		record := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			record[k] = floatNumbers(e)
+		}
+		return record
+	}
+	return v
+}
+
+// setExactNumbers walks a nested value along its column and replaces the int64
+// integers and decimals of its converted value, which went through float64,
+// with the exact values of the json.Number numbers of the value.
+func setExactNumbers(c *parquetschema.SchemaDefinition, v, converted interface{}) error {
+	if c == nil || len(c.RootColumn.Children) == 0 {
+		return nil
+	}
+	return setExactGroupNumbers(c.RootColumn, v, converted)
+}
+
+func setExactGroupNumbers(c *parquetschema.ColumnDefinition, v, converted interface{}) error {
+	switch kindOfGroup(c) {
+	case listGroup:
+		values, ok := v.([]interface{})
+		if !ok {
+			return nil
+		}
+		entries, err := groupEntries(c, converted)
+		if err != nil || len(entries) != len(values) {
+			return err
+		}
+		element := c.Children[0].Children[0]
+		for i, e := range values {
+			if err := setExactChildNumbers(element, e, entries[i]); err != nil {
+				return err
+			}
+		}
+	case recordGroup:
+		record, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		group, ok := converted.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("unexpected %T value of group %v", converted, c.SchemaElement.GetName())
+		}
+		for _, child := range c.Children {
+			if err := setExactChildNumbers(child, record[child.SchemaElement.GetName()], group); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// setExactChildNumbers sets the exact number of the value of a child column in
+// the converted value of its parent group. int32 integers are exact as float64
+// and are left as converted.
+func setExactChildNumbers(c *parquetschema.ColumnDefinition, v interface{}, parent map[string]interface{}) error {
+	name := c.SchemaElement.GetName()
+	if len(c.Children) > 0 {
+		return setExactGroupNumbers(c, v, parent[name])
+	}
+	n, ok := v.(json.Number)
+	if !ok {
+		return nil
+	}
+	switch e := c.SchemaElement; {
+	case isDecimal(e):
+		exact, err := decimalValue(e, n)
+		if err != nil {
+			return err
+		}
+		parent[name] = exact
+	case isInteger(e) && e.GetType() == parquet.Type_INT64:
+		exact, err := n.Int64()
+		if err != nil {
+			return err
+		}
+		parent[name] = exact
+	}
+	return nil
+}
+
+// findInvalidNested returns the path of the first invalid value of a nested
+// data point and the problem, or a nil error if it can't be found, e.g. as the
+// data point isn't a group column.
+func findInvalidNested(c *parquetschema.SchemaDefinition, v interface{}) (string, error) {
+	if c == nil || len(c.RootColumn.Children) == 0 {
+		return "", nil
+	}
+	return findInvalidValue(c.RootColumn, v)
+}
+
+// findInvalidValue walks a nested value along its column and returns the path
+// of the first value that doesn't match it, along with the problem. It is used
+// to point at the invalid value once the data point failed validation.
+func findInvalidValue(c *parquetschema.ColumnDefinition, v interface{}) (string, error) {
+	if len(c.Children) == 0 {
+		return "", checkJSONValue(c.SchemaElement, v)
+	}
+
+	switch kindOfGroup(c) {
+	case listGroup:
+		values, ok := v.([]interface{})
+		if !ok {
+			return "", errors.New("should be an array")
+		}
+		element := c.Children[0].Children[0]
+		for i, e := range values {
+			path, err := findInvalidChild(element, e)
+			if err != nil {
+				return fmt.Sprintf("[%v]%v", i, path), err
+			}
+		}
+	case recordGroup:
+		record, ok := v.(map[string]interface{})
+		if !ok {
+			return "", errors.New("should be an object")
+		}
+		for _, child := range c.Children {
+			name := child.SchemaElement.GetName()
+			path, err := findInvalidChild(child, record[name])
+			if err != nil {
+				return "." + name + path, err
+			}
+		}
+	}
+	return "", nil
+}
+
+func findInvalidChild(c *parquetschema.ColumnDefinition, v interface{}) (string, error) {
+	if v == nil {
+		if c.SchemaElement.GetRepetitionType() == parquet.FieldRepetitionType_REQUIRED {
+			return "", errors.New("missing required value")
+		}
+		return "", nil
+	}
+	return findInvalidValue(c, v)
+}
+
+// checkJSONValue checks that a json value can be converted to the type of a
+// primitive column.
+func checkJSONValue(e *parquet.SchemaElement, v interface{}) error {
+	switch {
+	case isUUID(e):
+		if s, ok := v.(string); !ok || !validUUID(s) {
+			return errors.New("should be a uuid")
+		}
+		return nil
+	case isString(e):
+		if _, ok := v.(string); !ok {
+			return errors.New("should be a string")
+		}
+		return nil
+	case isDate(e):
+		if s, ok := v.(string); !ok || !validTime("2006-01-02", s) {
+			return errors.New("should be a date formatted as YYYY-MM-DD")
+		}
+		return nil
+	case timestampUnit(e) != noTimeUnit:
+		if s, ok := v.(string); !ok || !validTime(time.RFC3339Nano, s) {
+			return errors.New("should be a RFC 3339 timestamp")
+		}
+		return nil
+	case isDecimal(e):
+		switch t := v.(type) {
+		case float64:
+			return nil
+		case json.Number:
+			_, err := decimalValue(e, t)
+			return err
+		}
+		return errors.New("should be a number")
+	}
+
+	switch e.GetType() {
+	case parquet.Type_BOOLEAN:
+		if _, ok := v.(bool); !ok {
+			return errors.New("should be a boolean")
+		}
+	case parquet.Type_INT32, parquet.Type_INT64:
+		switch t := v.(type) {
+		case float64:
+			if t == math.Trunc(t) {
+				return nil
+			}
+		case json.Number:
+			if validInteger(t) {
+				return nil
+			}
+		}
+		return errors.New("should be an integer")
+	case parquet.Type_FLOAT, parquet.Type_DOUBLE:
+		if _, ok := v.(float64); !ok {
+			return errors.New("should be a number")
+		}
+	}
+	return nil
+}
+
+func validTime(layout, s string) bool {
+	_, err := time.Parse(layout, s)
+	return err == nil
+}
+
+func validUUID(s string) bool {
+	_, err := uuid.Parse(s)
+	return err == nil
+}
@@ -0,0 +1,118 @@
+package parquet
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestNestedValue(t *testing.T) {
+	schemaDef := testNestedSchema(t)
+	expected := []interface{}{map[string]interface{}{"consent_reference": "sms", "is_consented": true}}
+	for _, v := range []interface{}{
+		`[{"consent_reference": "sms", "is_consented": true}]`,
+		[]byte(`[{"consent_reference": "sms", "is_consented": true}]`),
+		json.RawMessage(`[{"consent_reference": "sms", "is_consented": true}]`),
+		[]interface{}{map[string]interface{}{"consent_reference": "sms", "is_consented": true}},
+		[]map[string]interface{}{{"consent_reference": "sms", "is_consented": true}},
+		[]struct {
+			ConsentReference string `json:"consent_reference"`
+			IsConsented      bool   `json:"is_consented"`
+		}{{"sms", true}},
+	} {
+		nested, err := nestedValue(schemaDef.SubSchema("consent_references"), true, v)
+		if err != nil {
+			t.Fatalf("Unexpected error %v decoding %#v", err, v)
+		}
+		if !reflect.DeepEqual(nested, expected) {
+			t.Fatalf("Expected %#v got %#v", expected, nested)
+		}
+	}
+
+	nested, err := nestedValue(schemaDef.SubSchema("scores"), true, [][]int64{{1, 2}})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if expected := []interface{}{[]interface{}{json.Number("1"), json.Number("2")}}; !reflect.DeepEqual(nested, expected) {
+		t.Fatalf("Expected %#v got %#v", expected, nested)
+	}
+
+	if _, err := nestedValue(schemaDef.SubSchema("scores"), true, make(chan int)); err == nil {
+		t.Fatal("Expected error")
+	}
+}
+
+func TestNestedValueExactNumbers(t *testing.T) {
+	schemaDef := testNestedSchema(t)
+	c := schemaDef.SubSchema("transfer")
+
+	// Integers and decimals of values that are already decoded keep their
+	// digits, 2^53 + 1 can't be represented as float64.
+	nested, err := nestedValue(c, false, map[string]interface{}{"amount": 1234567.89, "sequence": int64(9007199254740993), "rate": 0.5})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	expected := map[string]interface{}{"amount": json.Number("1234567.89"), "sequence": json.Number("9007199254740993"), "rate": 0.5}
+	if !reflect.DeepEqual(nested, expected) {
+		t.Fatalf("Expected %#v got %#v", expected, nested)
+	}
+
+	nested, err = nestedValue(c, false, map[string]interface{}{"amount": json.Number("12345678901234.56"), "sequence": int64(9007199254740993)})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if path, err := findInvalidNested(c, nested); err != nil {
+		t.Fatalf("Unexpected error %v at %v", err, path)
+	}
+	converted := map[string]interface{}{"amount": []byte{0}, "sequence": int64(9007199254740992)}
+	if err := setExactNumbers(c, nested, converted); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	expectedConverted := map[string]interface{}{
+		"amount":   []byte{0x00, 0x04, 0x62, 0xd5, 0x3c, 0x8a, 0xba, 0xc0},
+		"sequence": int64(9007199254740993),
+	}
+	if !reflect.DeepEqual(converted, expectedConverted) {
+		t.Fatalf("Expected %#v got %#v", expectedConverted, converted)
+	}
+}
+
+func TestFindInvalidNested(t *testing.T) {
+	schemaDef := testNestedSchema(t)
+	for _, tc := range []struct {
+		dataPoint string
+		value     string
+		path      string
+	}{
+		{"consent_references", `[{"consent_reference": "sms"}, {}, {"update_at": "2022-06-01T10:00:00Z"}, {"update_at": "yesterday"}]`, "[3].update_at"},
+		{"consent_references", `[{"channels": ["email", 1]}]`, "[0].channels[1]"},
+		{"consent_references", `{"consent_reference": "sms"}`, ""},
+		{"scores", `[[1, 2], [3.5]]`, "[1][0]"},
+		{"address", `{"line": "1 The Street", "latitude": "51.5"}`, ".latitude"},
+	} {
+		var v interface{}
+		if err := json.Unmarshal([]byte(tc.value), &v); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		path, err := findInvalidNested(schemaDef.SubSchema(tc.dataPoint), v)
+		if err == nil {
+			t.Fatalf("Expected error finding invalid value of %v", tc.value)
+		}
+		if path != tc.path {
+			t.Fatalf("Expected path %q got %q", tc.path, path)
+		}
+	}
+
+	for _, dataPoint := range []string{"consent_references", "tags"} {
+		path, err := findInvalidNested(schemaDef.SubSchema(dataPoint), []interface{}{map[string]interface{}{"consent_reference": "sms"}})
+		if err != nil || path != "" {
+			t.Fatalf("Unexpected path %q err %v", path, err)
+		}
+	}
+
+	rowErr := &invalidRowError{dataPoint: "consent_references", path: "[3].update_at", err: errors.New("should be a RFC 3339 timestamp")}
+	if expected := "data point consent_references[3].update_at err=(should be a RFC 3339 timestamp)"; rowErr.Error() != expected {
+		t.Fatalf("Expected %v got %v", expected, rowErr.Error())
+	}
+}
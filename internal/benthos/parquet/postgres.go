@@ -0,0 +1,295 @@
+package parquet
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fraugster/parquet-go/parquet"
+	"github.com/fraugster/parquet-go/parquetschema"
+)
+
+// Native postgres arrays and composite types arrive in their text format, e.g.
+// {a,"b c",NULL} and (1,"x, y",), as drivers don't decode them. They are
+// decoded into the values json would be decoded into, following the parquet
+// column of the data point: integers and decimals are json.Number so that their
+// digits are kept, other numbers float64, booleans bool, timestamps RFC 3339
+// strings and every other value a string.
+
+// postgresArrayBounds matches the dimensions arrays with lower bounds other
+// than 1 are prefixed with, e.g. [0:1]={a,b}.
+var postgresArrayBounds = regexp.MustCompile(`^(\[-?\d+:-?\d+\])+=`)
+
+// decodeNested decodes the value of a nested data point of a column, which is
+// json unless it's a native postgres array of an array data point, or a
+// composite value of an object data point.
+func decodeNested(c *parquetschema.SchemaDefinition, isArray bool, s string) (interface{}, error) {
+	var column *parquetschema.ColumnDefinition
+	if c != nil {
+		column = c.RootColumn
+	}
+	switch {
+	case isArray && (strings.HasPrefix(s, "{") || postgresArrayBounds.MatchString(s)):
+		return decodePostgresArray(postgresArrayElement(column), s)
+	case !isArray && strings.HasPrefix(s, "("):
+		return decodePostgresComposite(column, s)
+	}
+	nested, err := decodeNestedJSON(c, []byte(s))
+	if err != nil {
+		return nil, errors.New("nested data point should be of type json, a postgres array or a composite type")
+	}
+	return nested, nil
+}
+
+// postgresArrayElement returns the element column of a list column, or nil if
+// the column isn't a list.
+func postgresArrayElement(c *parquetschema.ColumnDefinition) *parquetschema.ColumnDefinition {
+	if c == nil || len(c.Children) == 0 || kindOfGroup(c) != listGroup {
+		return nil
+	}
+	return c.Children[0].Children[0]
+}
+
+// decodePostgresArray decodes an array, elements are decoded following the
+// element column, or as strings if nil.
+func decodePostgresArray(element *parquetschema.ColumnDefinition, s string) ([]interface{}, error) {
+	fields, err := splitPostgresArray(s)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]interface{}, len(fields))
+	for i, f := range fields {
+		if values[i], err = decodePostgresField(element, f); err != nil {
+			return nil, fmt.Errorf("[%v] %v", i, err)
+		}
+	}
+	return values, nil
+}
+
+// decodePostgresComposite decodes a composite value into a record, its fields
+// are matched to the columns of the record by position.
+func decodePostgresComposite(c *parquetschema.ColumnDefinition, s string) (map[string]interface{}, error) {
+	if c == nil || len(c.Children) == 0 || kindOfGroup(c) != recordGroup {
+		return nil, errors.New("composite value of a column that isn't a record")
+	}
+	fields, err := splitPostgresComposite(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) != len(c.Children) {
+		return nil, fmt.Errorf("composite value has %v fields, expected %v", len(fields), len(c.Children))
+	}
+	record := make(map[string]interface{}, len(fields))
+	for i, f := range fields {
+		name := c.Children[i].SchemaElement.GetName()
+		if record[name], err = decodePostgresField(c.Children[i], f); err != nil {
+			return nil, fmt.Errorf("%v %v", name, err)
+		}
+	}
+	return record, nil
+}
+
+func decodePostgresField(c *parquetschema.ColumnDefinition, f *string) (interface{}, error) {
+	if f == nil {
+		return nil, nil
+	}
+	switch {
+	case c == nil:
+		return *f, nil
+	case len(c.Children) == 0:
+		return decodePostgresScalar(c.SchemaElement, *f)
+	case kindOfGroup(c) == listGroup:
+		return decodePostgresArray(postgresArrayElement(c), *f)
+	case kindOfGroup(c) == recordGroup:
+		return decodePostgresComposite(c, *f)
+	}
+	return nil, fmt.Errorf("map column %v can't be read from postgres values", c.SchemaElement.GetName())
+}
+
+// postgresTimestampLayouts are the text formats of timestamp and timestamptz
+// values, fractional seconds are optional when parsing.
+var postgresTimestampLayouts = []string{
+	"2006-01-02 15:04:05.999999999Z07:00:00",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999Z07",
+	"2006-01-02 15:04:05.999999999",
+}
+
+func decodePostgresScalar(e *parquet.SchemaElement, s string) (interface{}, error) {
+	switch {
+	case isString(e) || isUUID(e) || isDate(e):
+		return s, nil
+	case isDecimal(e):
+		if _, ok := new(big.Rat).SetString(s); !ok {
+			return nil, fmt.Errorf("invalid number %q", s)
+		}
+		return json.Number(s), nil
+	case timestampUnit(e) != noTimeUnit:
+		for _, layout := range postgresTimestampLayouts {
+			if t, err := time.Parse(layout, s); err == nil {
+				return t.UTC().Format(time.RFC3339Nano), nil
+			}
+		}
+		return nil, fmt.Errorf("invalid timestamp %q", s)
+	}
+
+	switch e.GetType() {
+	case parquet.Type_BOOLEAN:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid boolean %q", s)
+		}
+		return b, nil
+	case parquet.Type_INT32, parquet.Type_INT64:
+		if _, err := strconv.ParseInt(s, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid integer %q", s)
+		}
+		return json.Number(s), nil
+	case parquet.Type_FLOAT, parquet.Type_DOUBLE:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", s)
+		}
+		return f, nil
+	}
+	return s, nil
+}
+
+// splitPostgresArray returns the elements of an array, nil for NULL. Elements
+// of multidimensional arrays are returned as arrays.
+func splitPostgresArray(s string) ([]*string, error) {
+	if bounds := postgresArrayBounds.FindString(s); bounds != "" {
+		s = s[len(bounds):]
+	}
+	if len(s) < 2 || s[0] != '{' || s[len(s)-1] != '}' {
+		return nil, fmt.Errorf("invalid array %q", s)
+	}
+	body := s[1 : len(s)-1]
+	if strings.TrimSpace(body) == "" {
+		return []*string{}, nil
+	}
+
+	var elements []*string
+	for i := 0; ; {
+		for i < len(body) && body[i] == ' ' {
+			i++
+		}
+		var element *string
+		switch {
+		case i < len(body) && body[i] == '{':
+			end, err := matchingBrace(body, i)
+			if err != nil {
+				return nil, fmt.Errorf("invalid array %q", s)
+			}
+			nested := body[i : end+1]
+			element, i = &nested, end+1
+		case i < len(body) && body[i] == '"':
+			var b strings.Builder
+			for i++; i < len(body) && body[i] != '"'; i++ {
+				if body[i] == '\\' {
+					i++
+				}
+				if i < len(body) {
+					b.WriteByte(body[i])
+				}
+			}
+			if i >= len(body) {
+				return nil, fmt.Errorf("invalid array %q", s)
+			}
+			quoted := b.String()
+			element, i = &quoted, i+1
+		default:
+			start := i
+			for i < len(body) && body[i] != ',' {
+				i++
+			}
+			unquoted := strings.TrimSpace(body[start:i])
+			if !strings.EqualFold(unquoted, "NULL") {
+				element = &unquoted
+			}
+		}
+		elements = append(elements, element)
+
+		for i < len(body) && body[i] == ' ' {
+			i++
+		}
+		if i >= len(body) {
+			return elements, nil
+		}
+		if body[i] != ',' {
+			return nil, fmt.Errorf("invalid array %q", s)
+		}
+		i++
+	}
+}
+
+// matchingBrace returns the index of the brace closing the one at start,
+// skipping quoted elements.
+func matchingBrace(s string, start int) (int, error) {
+	depth, quoted := 0, false
+	for i := start; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\':
+			i++
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+			if depth == 0 {
+				return i, nil
+			}
+		}
+	}
+	return 0, errors.New("unbalanced braces")
+}
+
+// splitPostgresComposite returns the fields of a composite value, nil for
+// NULL which is an empty unquoted field.
+func splitPostgresComposite(s string) ([]*string, error) {
+	if len(s) < 2 || s[0] != '(' || s[len(s)-1] != ')' {
+		return nil, fmt.Errorf("invalid composite value %q", s)
+	}
+	body := s[1 : len(s)-1]
+
+	var fields []*string
+	var b strings.Builder
+	present, quoted := false, false
+	for i := 0; i <= len(body); i++ {
+		if i == len(body) || body[i] == ',' && !quoted {
+			if i == len(body) && quoted {
+				return nil, fmt.Errorf("invalid composite value %q", s)
+			}
+			if present {
+				field := b.String()
+				fields = append(fields, &field)
+			} else {
+				fields = append(fields, nil)
+			}
+			b.Reset()
+			present = false
+			continue
+		}
+		switch c := body[i]; {
+		case c == '\\' && i+1 < len(body):
+			i++
+			b.WriteByte(body[i])
+		case c == '"' && quoted && i+1 < len(body) && body[i+1] == '"':
+			i++
+			b.WriteByte('"')
+		case c == '"':
+			quoted = !quoted
+		default:
+			b.WriteByte(c)
+		}
+		present = true
+	}
+	return fields, nil
+}
@@ -0,0 +1,252 @@
+package parquet
+
+import (
+	"encoding/json"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/fraugster/parquet-go/parquetschema"
+)
+
+func testNestedSchema(t *testing.T) *parquetschema.SchemaDefinition {
+	schemaDef, err := parquetschema.ParseSchemaDefinition(`message test {
+		optional group consent_references (LIST) {
+			repeated group list {
+				optional group element {
+					optional binary consent_reference (STRING);
+					optional boolean is_consented;
+					optional int64 update_at (TIMESTAMP(MICROS, true));
+					optional group channels (LIST) {
+						repeated group list {
+							optional binary element (STRING);
+						}
+					}
+				}
+			}
+		}
+		optional group scores (LIST) {
+			repeated group list {
+				optional group element (LIST) {
+					repeated group list {
+						optional int64 element;
+					}
+				}
+			}
+		}
+		optional group address {
+			optional binary line (STRING);
+			optional binary postcode (STRING);
+			optional double latitude;
+		}
+		optional binary tags (STRING);
+		optional group payment {
+			optional fixed_len_byte_array(16) id (UUID);
+			optional fixed_len_byte_array(8) amount (DECIMAL(18, 2));
+			optional binary balance (DECIMAL(20, 2));
+		}
+		optional group amounts (LIST) {
+			repeated group list {
+				optional fixed_len_byte_array(8) element (DECIMAL(18, 2));
+			}
+		}
+		optional group payment_ids (LIST) {
+			repeated group list {
+				optional fixed_len_byte_array(16) element (UUID);
+			}
+		}
+		optional group transfer {
+			optional fixed_len_byte_array(8) amount (DECIMAL(18, 2));
+			optional int64 sequence;
+			optional double rate;
+		}
+	}`)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	return schemaDef
+}
+
+func TestDecodeNested(t *testing.T) {
+	schemaDef := testNestedSchema(t)
+	for _, tc := range []struct {
+		dataPoint string
+		isArray   bool
+		value     string
+		expected  interface{}
+	}{
+		{
+			dataPoint: "consent_references",
+			isArray:   true,
+			value:     `{"(email-insurance,t,\"2022-06-01 10:00:00.5+01\",\"{email,\\\"sms, push\\\"}\")","(sms,f,,)",NULL}`,
+			expected: []interface{}{
+				map[string]interface{}{"consent_reference": "email-insurance", "is_consented": true, "update_at": "2022-06-01T09:00:00.5Z", "channels": []interface{}{"email", "sms, push"}},
+				map[string]interface{}{"consent_reference": "sms", "is_consented": false, "update_at": nil, "channels": nil},
+				nil,
+			},
+		},
+		{
+			dataPoint: "consent_references",
+			isArray:   true,
+			value:     `[{"consent_reference": "sms"}]`,
+			expected:  []interface{}{map[string]interface{}{"consent_reference": "sms"}},
+		},
+		{
+			dataPoint: "scores",
+			isArray:   true,
+			value:     `[0:1]={{1,2},{3,NULL}}`,
+			expected:  []interface{}{[]interface{}{json.Number("1"), json.Number("2")}, []interface{}{json.Number("3"), nil}},
+		},
+		{
+			dataPoint: "scores",
+			isArray:   true,
+			value:     `{}`,
+			expected:  []interface{}{},
+		},
+		{
+			dataPoint: "address",
+			value:     `("1 ""The"" Street",,51.5)`,
+			expected:  map[string]interface{}{"line": `1 "The" Street`, "postcode": nil, "latitude": 51.5},
+		},
+		{
+			dataPoint: "address",
+			value:     `("",N1,)`,
+			expected:  map[string]interface{}{"line": "", "postcode": "N1", "latitude": nil},
+		},
+		{
+			dataPoint: "tags",
+			isArray:   true,
+			value:     `{a, "b}", null ,"NULL"}`,
+			expected:  []interface{}{"a", "b}", nil, "NULL"},
+		},
+		{
+			dataPoint: "payment",
+			value:     `(0b9c2a4e-3f7d-4c1a-9e57-2d7c8b1f6a30,-12.50,1234567890.99)`,
+			expected:  map[string]interface{}{"id": "0b9c2a4e-3f7d-4c1a-9e57-2d7c8b1f6a30", "amount": json.Number("-12.50"), "balance": json.Number("1234567890.99")},
+		},
+		{
+			dataPoint: "amounts",
+			isArray:   true,
+			value:     `{1.25,-3.00,NULL}`,
+			expected:  []interface{}{json.Number("1.25"), json.Number("-3.00"), nil},
+		},
+		{
+			dataPoint: "payment_ids",
+			isArray:   true,
+			value:     `{0b9c2a4e-3f7d-4c1a-9e57-2d7c8b1f6a30,NULL}`,
+			expected:  []interface{}{"0b9c2a4e-3f7d-4c1a-9e57-2d7c8b1f6a30", nil},
+		},
+	} {
+		c := schemaDef.SubSchema(tc.dataPoint)
+		v, err := decodeNested(c, tc.isArray, tc.value)
+		if err != nil {
+			t.Fatalf("Unexpected error %v decoding %v", err, tc.value)
+		}
+		if !reflect.DeepEqual(v, tc.expected) {
+			t.Fatalf("Expected %#v got %#v", tc.expected, v)
+		}
+		// Decoded values pass the checks of json values.
+		if path, err := findInvalidNested(c, v); err != nil {
+			t.Fatalf("Unexpected error %v at %v of %v", err, path, tc.value)
+		}
+	}
+}
+
+func TestDecodeNestedInvalidValues(t *testing.T) {
+	schemaDef := testNestedSchema(t)
+	for _, tc := range []struct {
+		dataPoint string
+		isArray   bool
+		value     string
+		path      string
+		problem   string
+	}{
+		{"payment", false, `(not-a-uuid,1.00,)`, ".id", "should be a uuid"},
+		{"payment_ids", true, `{0b9c2a4e-3f7d-4c1a-9e57-2d7c8b1f6a30,nope}`, "[1]", "should be a uuid"},
+		{"payment", false, `(,1.005,)`, ".amount", "1.005 has more than 2 decimal places"},
+		{"amounts", true, `{1.25,12345678901234567.89}`, "[1]", "12345678901234567.89 doesn't fit DECIMAL(18, 2)"},
+	} {
+		c := schemaDef.SubSchema(tc.dataPoint)
+		v, err := decodeNested(c, tc.isArray, tc.value)
+		if err != nil {
+			t.Fatalf("Unexpected error %v decoding %v", err, tc.value)
+		}
+		path, err := findInvalidNested(c, v)
+		if err == nil || path != tc.path || err.Error() != tc.problem {
+			t.Fatalf("Expected %v at %v of %v got %v at %v", tc.problem, tc.path, tc.value, err, path)
+		}
+	}
+}
+
+func TestSetExactDecimals(t *testing.T) {
+	schemaDef := testNestedSchema(t)
+
+	// Decimals that lose digits as float64 are set from their text.
+	c := schemaDef.SubSchema("payment")
+	v, err := decodeNested(c, false, `(,-9876543210987654.32,123456789012345678.91)`)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	converted := map[string]interface{}{"amount": []byte{0}, "balance": []byte{0}}
+	if err := setExactNumbers(c, v, converted); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	expected := map[string]interface{}{
+		"amount":  []byte{0xf2, 0x4b, 0x25, 0xa0, 0xb6, 0x07, 0x4b, 0x88},
+		"balance": []byte{0x00, 0xab, 0x54, 0xa9, 0x8c, 0xeb, 0x1f, 0x0a, 0xd3},
+	}
+	if !reflect.DeepEqual(converted, expected) {
+		t.Fatalf("Expected %#v got %#v", expected, converted)
+	}
+	for name, e := range expected {
+		column := c.SubSchema(name).RootColumn.SchemaElement
+		d, err := logicalValue(column, e)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if s := d.(*big.Rat).FloatString(2); s != map[string]string{"amount": "-9876543210987654.32", "balance": "123456789012345678.91"}[name] {
+			t.Fatalf("Unexpected %v %v", name, s)
+		}
+	}
+
+	// Elements of lists are set in their entries, NULL elements have none.
+	c = schemaDef.SubSchema("amounts")
+	if v, err = decodeNested(c, true, `{0.10,NULL,-3.00}`); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	list := map[string]interface{}{"list": []map[string]interface{}{{"element": 0.1}, {}, {"element": -3.0}}}
+	if err := setExactNumbers(c, v, list); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	expectedList := map[string]interface{}{"list": []map[string]interface{}{
+		{"element": []byte{0, 0, 0, 0, 0, 0, 0, 10}},
+		{},
+		{"element": []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xfe, 0xd4}},
+	}}
+	if !reflect.DeepEqual(list, expectedList) {
+		t.Fatalf("Expected %#v got %#v", expectedList, list)
+	}
+}
+
+func TestDecodeNestedErrors(t *testing.T) {
+	schemaDef := testNestedSchema(t)
+	for _, tc := range []struct {
+		dataPoint string
+		isArray   bool
+		value     string
+	}{
+		{"consent_references", true, `{"(email,maybe,,)"}`},
+		{"consent_references", true, `{"(email,t)"}`},
+		{"scores", true, `{{1,2}`},
+		{"scores", true, `{{a}}`},
+		{"address", false, `("unterminated,,)`},
+		{"tags", false, `(a)`},
+		{"address", false, `{"line": `},
+		{"payment", false, `(,12.x,)`},
+		{"amounts", true, `{1.25,abc}`},
+	} {
+		if _, err := decodeNested(schemaDef.SubSchema(tc.dataPoint), tc.isArray, tc.value); err == nil {
+			t.Fatalf("Expected error decoding %v", tc.value)
+		}
+	}
+}
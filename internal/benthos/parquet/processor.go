@@ -1,136 +1,642 @@
 package parquet
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/benthosdev/benthos/v4/public/service"
 	goparquet "github.com/fraugster/parquet-go"
 	"github.com/fraugster/parquet-go/parquet"
+	"github.com/fraugster/parquet-go/parquetschema"
 	"github.com/google/uuid"
 	"github.com/utilitywarehouse/data-products-definitions/pkg/catalog/v1"
 )
 
-func New(cat catalog.Catalog) error {
-	configSpec := service.NewConfigSpec().
+const (
+	defaultRowGroupSize = 8 * 1024 * 1024
+	defaultPageSize     = 1024 * 1024
+)
+
+func processorConfig() *service.ConfigSpec {
+	spec := service.NewConfigSpec().
 		Summary("Processor for generating parquet files using sql_raw input.").
-		Field(service.NewStringField("dataProductID").
-			Description("Data product id defined in the data-products-definitions").
-			Example(uuid.NewString()))
+		Description("Rows are converted and written as the batch is read, row groups are flushed as they fill up and a new file is started whenever the current one reaches `max_file_rows` or `max_file_size`, so that only the open file and one row group are buffered uncompressed. When `output` is set every file is written to it as soon as it is closed, so memory is bounded regardless of the size of the batch, otherwise each file is emitted as a separate message of the resulting batch.").
+		Field(dataProductIDField()).
+		Field(service.NewStringEnumField("compression", "snappy", "zstd", "gzip", "lz4", "none").
+			Description("The compression codec of the column chunks, `lz4` writes the `LZ4_RAW` codec.").
+			Default("snappy")).
+		Field(service.NewIntField("compression_level").
+			Description("An optional level of the compression codec: 1 to 9 for `gzip`, 1 to 22 for `zstd` and 0 (fast) to 9 for `lz4`. Compressors are shared by the whole process, so every `uw_parquet` processor using a codec must use the same level.").
+			Optional().
+			Advanced()).
+		Field(service.NewIntField("row_group_size").
+			Description("The approximate uncompressed size in bytes a row group is flushed at. Only one row group is buffered uncompressed at a time.").
+			Default(defaultRowGroupSize).
+			Advanced()).
+		Field(service.NewIntField("page_size").
+			Description("The approximate uncompressed size in bytes of the data pages of a column chunk.").
+			Default(defaultPageSize).
+			Advanced()).
+		Field(service.NewStringListField("dictionary_disabled_columns").
+			Description("Top level columns of primitive types that are written without dictionary encoding, e.g. high cardinality ids. These columns are written with the default page size.").
+			Default([]string{}).
+			Advanced()).
+		Field(service.NewIntField("data_page_version").
+			Description("The version of the data pages, either 1 or 2. Version 2 pages may not be supported by older readers.").
+			Default(1).
+			Advanced()).
+		Field(service.NewStringListField("bloom_filter_columns").
+			Description("Top level data points of primitive types, other than booleans, a bloom filter is written for in every row group, so that point lookups can skip row groups. Min, max and null count statistics are written for every column regardless.").
+			Default([]string{}).
+			Example([]string{"citizen_id"})).
+		Field(service.NewFloatField("bloom_filter_fpp").
+			Description("The false positive probability the bloom filters are sized for.").
+			Default(defaultBloomFilterFPP).
+			Advanced()).
+		Field(service.NewIntField("max_file_rows").
+			Description("The maximum number of rows written to a file before a new one is started. If value <= 0, files are not limited by rows.").
+			Default(0).
+			Example(1000000)).
+		Field(service.NewIntField("max_file_size").
+			Description("The approximate maximum size in bytes of a file before a new one is started. If value <= 0, files are not limited by size.").
+			Default(0).
+			Example(64 * 1024 * 1024))
+	return withProcessingFields(spec)
+}
 
-	constructor := func(conf *service.ParsedConfig, mgr *service.Resources) (service.BatchProcessor, error) {
-		dataProductID, err := conf.FieldString("dataProductID")
-		if err != nil {
-			return nil, err
-		}
-		return newParquetProcessor(cat, dataProductID, mgr.Logger()), nil
-	}
+func dataProductIDField() *service.ConfigField {
+	return service.NewStringField("dataProductID").
+		Description("Data product id defined in the data-products-definitions").
+		Example(uuid.NewString())
+}
 
-	err := service.RegisterBatchProcessor("uw_parquet", configSpec, constructor)
-	if err != nil {
-		return err
+// withProcessingFields adds the fields shared by the processors of every
+// format, which validate and convert the rows the same way.
+func withProcessingFields(spec *service.ConfigSpec) *service.ConfigSpec {
+	return spec.
+		Field(service.NewIntField("max_invalid_rows").
+			Description("The maximum number of rows of a run that may fail validation. Invalid rows are skipped, or diverted to `rejects`, until either limit is exceeded, then processing fails with a summary of the top errors per data point. When neither limit is set, the first invalid row fails the batch unless `rejects` is set.").
+			Optional().
+			Example(100)).
+		Field(service.NewFloatField("max_invalid_ratio").
+			Description("The maximum ratio, between 0 and 1, of the rows processed by a run that may fail validation. It is checked after each batch.").
+			Optional().
+			Example(0.01)).
+		Field(service.NewIntField("workers").
+			Description("The number of goroutines validating and converting the rows of a batch, rows are still written to the files in the order of the batch.").
+			Default(1).
+			Advanced()).
+		Field(service.NewOutputField("output").
+			Description("An output every file is written to as soon as it is closed, before the next rows of the batch are written, instead of being emitted in the resulting batch. Files already written stay written if the batch fails later on.").
+			Optional()).
+		Field(rejectsField()).
+		Field(provenanceField()).
+		Field(schemaGuardField()).
+		Field(mappingField())
+}
+
+// New registers the uw_parquet, uw_avro and uw_ndjson processors, gitHash is
+// the commit the binary is built from and is written to the provenance of every
+// file.
+func New(cat Definitions, gitHash string) error {
+	for name, p := range map[string]struct {
+		spec        *service.ConfigSpec
+		constructor func(Definitions, *service.ParsedConfig, *service.Logger) (*dataProductProcessor, error)
+	}{
+		"uw_parquet": {processorConfig(), newParquetProcessorFromConfig},
+		"uw_avro":    {avroProcessorConfig(), newAvroProcessorFromConfig},
+		"uw_ndjson":  {ndjsonProcessorConfig(), newNDJSONProcessorFromConfig},
+	} {
+		newProcessor := p.constructor
+		constructor := func(conf *service.ParsedConfig, mgr *service.Resources) (service.BatchProcessor, error) {
+			r, err := newProcessor(cat, conf, mgr.Logger())
+			if err != nil {
+				return nil, err
+			}
+			r.provenance.GitHash = gitHash
+			return r, nil
+		}
+		if err := service.RegisterBatchProcessor(name, p.spec, constructor); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-type parquetProcessor struct {
-	catalog       catalog.Catalog
+// fileFormat encodes the converted rows of a batch into files.
+type fileFormat interface {
+	name() string
+	// newEncoder returns an encoder passing every file to emit once it is
+	// complete.
+	newEncoder(resolved *resolvedDefinition, provenance Provenance, emit func([]byte) error) (rowEncoder, error)
+}
+
+type rowEncoder interface {
+	add(row map[string]interface{}) error
+	close() error
+}
+
+// dataProductProcessor validates the rows of a batch against the definition of
+// a data product and encodes them into files of its format.
+type dataProductProcessor struct {
+	catalog       Definitions
 	dataProductID string
-	logger        *service.Logger
+	format        fileFormat
+
+	workers int
+	// files, if set, is the output files are written to as they are closed.
+	files       *service.OwnedOutput
+	rejects     *rejectsConfig
+	budget      *errorBudget
+	provenance  Provenance
+	sourceQuery *service.InterpolatedString
+	guard       *schemaGuard
+	mapping     *columnMapping
+
+	definitionMu sync.Mutex
+	resolved     *resolvedDefinition
+	// publishedHash is the schema hash of the files published by the run, so
+	// that a reloaded definition can't publish files under another schema.
+	publishedHash string
+
+	logger *service.Logger
 }
 
-func newParquetProcessor(catalog catalog.Catalog, dataProductID string, logger *service.Logger) *parquetProcessor {
-	return &parquetProcessor{
+func newDataProductProcessor(catalog Definitions, dataProductID string, format fileFormat, logger *service.Logger) *dataProductProcessor {
+	return &dataProductProcessor{
 		catalog:       catalog,
 		dataProductID: dataProductID,
+		format:        format,
+		workers:       1,
 		logger:        logger,
 	}
 }
 
-func (r *parquetProcessor) ProcessBatch(ctx context.Context, batch service.MessageBatch) ([]service.MessageBatch, error) {
-	r.logger.Infof("Parquet processor: processing batch of size %v", len(batch))
-	if len(batch) == 0 {
-		return nil, nil
+// newDataProductProcessorFromConfig parses the fields shared by every format.
+func newDataProductProcessorFromConfig(cat Definitions, conf *service.ParsedConfig, format fileFormat, logger *service.Logger) (*dataProductProcessor, error) {
+	dataProductID, err := conf.FieldString("dataProductID")
+	if err != nil {
+		return nil, err
 	}
+	r := newDataProductProcessor(cat, dataProductID, format, logger)
 
-	def, err := r.catalog.GetByID(r.dataProductID)
-	if err != nil {
-		return nil, fmt.Errorf("could not find data product with id %v err=%v", r.dataProductID, err)
+	if r.workers, err = conf.FieldInt("workers"); err != nil {
+		return nil, err
+	}
+	if r.workers <= 0 {
+		return nil, fmt.Errorf("workers must be greater than 0, got %v", r.workers)
 	}
 
-	schemaDef, err := catalog.ToParquetSchema(*def)
-	if err != nil {
+	if conf.Contains("max_invalid_rows") || conf.Contains("max_invalid_ratio") {
+		maxInvalidRows, maxInvalidRatio := -1, -1.0
+		if conf.Contains("max_invalid_rows") {
+			if maxInvalidRows, err = conf.FieldInt("max_invalid_rows"); err != nil {
+				return nil, err
+			}
+			if maxInvalidRows < 0 {
+				return nil, fmt.Errorf("max_invalid_rows must not be negative, got %v", maxInvalidRows)
+			}
+		}
+		if conf.Contains("max_invalid_ratio") {
+			if maxInvalidRatio, err = conf.FieldFloat("max_invalid_ratio"); err != nil {
+				return nil, err
+			}
+			if maxInvalidRatio < 0 || maxInvalidRatio > 1 {
+				return nil, fmt.Errorf("max_invalid_ratio must be between 0 and 1, got %v", maxInvalidRatio)
+			}
+		}
+		r.budget = newErrorBudget(int64(maxInvalidRows), maxInvalidRatio)
+	}
+
+	if r.provenance, r.sourceQuery, err = provenanceFromParsed(conf.Namespace("provenance")); err != nil {
 		return nil, err
 	}
 
-	buf := bytes.Buffer{}
-	fw := goparquet.NewFileWriter(&buf,
-		goparquet.WithCompressionCodec(parquet.CompressionCodec_SNAPPY),
-		goparquet.WithSchemaDefinition(schemaDef),
-		goparquet.WithCreator("write-lowlevel"),
-	)
+	if conf.Contains("output") {
+		if r.files, err = conf.FieldOutput("output"); err != nil {
+			return nil, err
+		}
+	}
 
-	for _, msg := range batch {
-		str, err := msg.AsStructured()
-		if err != nil {
+	if conf.Contains("rejects") {
+		if r.rejects, err = rejectsConfigFromParsed(conf.Namespace("rejects")); err != nil {
 			return nil, err
 		}
-		if err := processRow(str, def, fw); err != nil {
+	}
+
+	if conf.Contains("schema_guard") {
+		if r.guard, err = schemaGuardFromParsed(conf.Namespace("schema_guard")); err != nil {
+			return nil, err
+		}
+	}
+
+	mappings, err := conf.FieldObjectList("mapping")
+	if err != nil {
+		return nil, err
+	}
+	if r.mapping, err = columnMappingFromParsed(mappings); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// parquetFormat writes rows to parquet files.
+type parquetFormat struct {
+	writerOptions writerOptions
+	statsOptions  statsOptions
+	maxFileRows   int64
+	maxFileSize   int64
+}
+
+func newParquetFormat() *parquetFormat {
+	return &parquetFormat{
+		writerOptions: writerOptions{
+			codec:           parquet.CompressionCodec_SNAPPY,
+			rowGroupSize:    defaultRowGroupSize,
+			pageSize:        defaultPageSize,
+			dataPageVersion: 1,
+		},
+		statsOptions: statsOptions{
+			bloomFilterFPP: defaultBloomFilterFPP,
+		},
+	}
+}
+
+func (f *parquetFormat) name() string {
+	return "Parquet"
+}
+
+func (f *parquetFormat) newEncoder(resolved *resolvedDefinition, provenance Provenance, emit func([]byte) error) (rowEncoder, error) {
+	w := newRollingWriter(f.maxFileRows, f.maxFileSize, provenance.metadata(), func(w io.Writer, extra ...goparquet.FileWriterOption) (*goparquet.FileWriter, error) {
+		return f.writerOptions.newFileWriter(w, resolved.schemaDef, extra...)
+	}, emit)
+	w.stats = &f.statsOptions
+	return w, nil
+}
+
+func newParquetProcessor(catalog Definitions, dataProductID string, logger *service.Logger) *dataProductProcessor {
+	return newDataProductProcessor(catalog, dataProductID, newParquetFormat(), logger)
+}
+
+func newParquetProcessorFromConfig(cat Definitions, conf *service.ParsedConfig, logger *service.Logger) (*dataProductProcessor, error) {
+	f := newParquetFormat()
+
+	compression, err := conf.FieldString("compression")
+	if err != nil {
+		return nil, err
+	}
+	codec, ok := compressionCodecs[compression]
+	if !ok {
+		return nil, fmt.Errorf("unsupported compression %v", compression)
+	}
+	compressionLevel := noCompressionLevel
+	if conf.Contains("compression_level") {
+		if compressionLevel, err = conf.FieldInt("compression_level"); err != nil {
 			return nil, err
 		}
+	}
+	if err := registerCompression(codec, compressionLevel); err != nil {
+		return nil, err
+	}
+	f.writerOptions.codec = codec
+
+	rowGroupSize, err := conf.FieldInt("row_group_size")
+	if err != nil {
+		return nil, err
+	}
+	if rowGroupSize <= 0 {
+		return nil, fmt.Errorf("row_group_size must be greater than 0, got %v", rowGroupSize)
+	}
+	f.writerOptions.rowGroupSize = int64(rowGroupSize)
+
+	pageSize, err := conf.FieldInt("page_size")
+	if err != nil {
+		return nil, err
+	}
+	if pageSize <= 0 {
+		return nil, fmt.Errorf("page_size must be greater than 0, got %v", pageSize)
+	}
+	f.writerOptions.pageSize = int64(pageSize)
+
+	noDictionary, err := conf.FieldStringList("dictionary_disabled_columns")
+	if err != nil {
+		return nil, err
+	}
+	if len(noDictionary) > 0 {
+		f.writerOptions.noDictionary = map[string]bool{}
+		for _, c := range noDictionary {
+			f.writerOptions.noDictionary[c] = true
+		}
+	}
+
+	if f.writerOptions.dataPageVersion, err = conf.FieldInt("data_page_version"); err != nil {
+		return nil, err
+	}
+	if v := f.writerOptions.dataPageVersion; v != 1 && v != 2 {
+		return nil, fmt.Errorf("data_page_version must be 1 or 2, got %v", v)
+	}
+
+	if f.statsOptions.bloomFilterColumns, err = conf.FieldStringList("bloom_filter_columns"); err != nil {
+		return nil, err
+	}
+	if f.statsOptions.bloomFilterFPP, err = conf.FieldFloat("bloom_filter_fpp"); err != nil {
+		return nil, err
+	}
+	if fpp := f.statsOptions.bloomFilterFPP; fpp <= 0 || fpp >= 1 {
+		return nil, fmt.Errorf("bloom_filter_fpp must be between 0 and 1, got %v", fpp)
+	}
 
+	maxFileRows, err := conf.FieldInt("max_file_rows")
+	if err != nil {
+		return nil, err
 	}
-	if err := fw.Close(); err != nil {
+	f.maxFileRows = int64(maxFileRows)
+
+	maxFileSize, err := conf.FieldInt("max_file_size")
+	if err != nil {
 		return nil, err
 	}
-	outMsg := service.NewMessage(buf.Bytes())
-	return []service.MessageBatch{{outMsg}}, nil
+	f.maxFileSize = int64(maxFileSize)
+
+	r, err := newDataProductProcessorFromConfig(cat, conf, f, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	// Resolve the definition up front so that a misconfigured data product
+	// fails at startup rather than once the query has run.
+	resolved, err := r.definition()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range f.statsOptions.bloomFilterColumns {
+		c := resolved.schemaDef.SubSchema(name)
+		if c == nil || len(c.RootColumn.Children) > 0 || c.SchemaElement().GetType() == parquet.Type_BOOLEAN || c.SchemaElement().GetType() == parquet.Type_INT96 {
+			return nil, fmt.Errorf("bloom filter column %v must be a top level data point of a primitive type other than boolean", name)
+		}
+	}
+
+	return r, nil
+}
+
+func (r *dataProductProcessor) ProcessBatch(ctx context.Context, batch service.MessageBatch) ([]service.MessageBatch, error) {
+	out, published, err := r.processBatch(ctx, batch)
+	var publishedSchema *parquetschema.SchemaDefinition
+	if published != nil {
+		r.definitionMu.Lock()
+		r.publishedHash = published.schemaHash
+		r.definitionMu.Unlock()
+		publishedSchema = published.schemaDef
+	}
+	if r.guard != nil {
+		r.guard.batchDone(publishedSchema, err)
+	}
+	return out, err
+}
+
+// processBatch returns the files and rejects of a batch, and the definition the
+// files were written with or nil if the batch has none.
+func (r *dataProductProcessor) processBatch(ctx context.Context, batch service.MessageBatch) ([]service.MessageBatch, *resolvedDefinition, error) {
+	r.logger.Infof("%v processor: processing batch of size %v", r.format.name(), len(batch))
+	if len(batch) == 0 {
+		return nil, nil, nil
+	}
+
+	resolved, err := r.definition()
+	if err != nil {
+		return nil, nil, err
+	}
+	def := resolved.def
+
+	provenance := r.provenance
+	provenance.DataProductID = def.DataProduct.ID
+	provenance.DataProductFQN = def.DataProduct.FQN
+	provenance.SchemaHash = resolved.schemaHash
+	if r.sourceQuery != nil {
+		provenance.SourceQuery = r.sourceQuery.String(batch[0])
+	}
+
+	var outBatch service.MessageBatch
+	var files int
+	w, err := r.format.newEncoder(resolved, provenance, func(file []byte) error {
+		files++
+		if r.files != nil {
+			// The file is written before the next rows, so that it isn't
+			// held until the whole batch is written.
+			if err := r.files.Write(ctx, service.NewMessage(file)); err != nil {
+				return fmt.Errorf("error writing %v file %v", strings.ToLower(r.format.name()), err)
+			}
+			return nil
+		}
+		outBatch = append(outBatch, service.NewMessage(file))
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var invalid []*invalidRowError
+	var rejects []reject
+	if err := convertBatch(batch, resolved, r.mapping, r.workers, func(i int, converted convertedRow) error {
+		if converted.err == nil {
+			if err := w.add(converted.row); err != nil {
+				return fmt.Errorf("error writing to %v format %v", strings.ToLower(r.format.name()), err)
+			}
+			return nil
+		}
+		// Only invalid rows are skipped or rejected, any other error fails
+		// the batch.
+		var rowErr *invalidRowError
+		if !errors.As(converted.err, &rowErr) {
+			return converted.err
+		}
+		if r.rejects == nil && r.budget == nil {
+			return rowErr
+		}
+		invalid = append(invalid, rowErr)
+		if r.rejects == nil {
+			return nil
+		}
+		rej, err := newReject(rowErr, batch[i])
+		if err != nil {
+			return err
+		}
+		rejects = append(rejects, rej)
+		return nil
+	}); err != nil {
+		return nil, nil, err
+	}
+	if r.budget != nil {
+		if err := r.budget.add(int64(len(batch)), invalid); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(invalid) > 0 && r.rejects == nil {
+		r.logger.Warnf("%v processor: skipped %v invalid rows of the batch", r.format.name(), len(invalid))
+	}
+
+	if err := w.close(); err != nil {
+		return nil, nil, err
+	}
+	if files > 1 {
+		r.logger.Infof("%v processor: batch written to %v files", r.format.name(), files)
+	}
+	var published *resolvedDefinition
+	if files > 0 {
+		published = resolved
+	}
+
+	if len(rejects) > 0 {
+		r.logger.Warnf("%v processor: rejected %v invalid rows of the batch", r.format.name(), len(rejects))
+		msg, err := r.rejects.newMessage(rejects)
+		if err != nil {
+			return nil, nil, err
+		}
+		outBatch = append(outBatch, msg)
+	}
+	return []service.MessageBatch{outBatch}, published, nil
 }
 
-func (r *parquetProcessor) Close(ctx context.Context) error {
+func (r *dataProductProcessor) Close(ctx context.Context) error {
+	if r.files != nil {
+		if err := r.files.Close(ctx); err != nil {
+			return err
+		}
+	}
+	if r.guard != nil {
+		return r.guard.writeRecord(ctx)
+	}
+	return nil
+}
+
+// convertedRow is a row converted to its parquet types, or why it couldn't be.
+type convertedRow struct {
+	row map[string]interface{}
+	err error
+}
+
+// convertChunkSize is the number of consecutive rows a worker converts at a
+// time, which keeps contention low.
+const convertChunkSize = 64
+
+// convertBatch converts the messages of a batch with the given number of
+// workers and passes the rows to fn in the order of the batch. Rows are
+// converted a window of chunks at a time, so that only the rows of the current
+// window are held in memory.
+func convertBatch(batch service.MessageBatch, resolved *resolvedDefinition, mapping *columnMapping, workers int, fn func(i int, row convertedRow) error) error {
+	window := convertChunkSize * workers
+	rows := make([]convertedRow, window)
+	for start := 0; start < len(batch); start += window {
+		end := start + window
+		if end > len(batch) {
+			end = len(batch)
+		}
+		convertWindow(batch[start:end], rows[:end-start], resolved, mapping, workers)
+		for j := range rows[:end-start] {
+			if err := fn(start+j, rows[j]); err != nil {
+				return err
+			}
+			rows[j] = convertedRow{}
+		}
+	}
 	return nil
 }
 
-func processRow(row interface{}, def *catalog.Definition, fw *goparquet.FileWriter) error {
+// convertWindow converts the messages of a window into rows.
+func convertWindow(batch service.MessageBatch, rows []convertedRow, resolved *resolvedDefinition, mapping *columnMapping, workers int) {
+	if workers <= 1 {
+		for i, msg := range batch {
+			rows[i].row, rows[i].err = convertMessage(msg, resolved, mapping)
+		}
+		return
+	}
+
+	const chunkSize = convertChunkSize
+	var next int64
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				start := int(atomic.AddInt64(&next, chunkSize)) - chunkSize
+				if start >= len(batch) {
+					return
+				}
+				end := start + chunkSize
+				if end > len(batch) {
+					end = len(batch)
+				}
+				for j := start; j < end; j++ {
+					rows[j].row, rows[j].err = convertMessage(batch[j], resolved, mapping)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func convertMessage(msg *service.Message, resolved *resolvedDefinition, mapping *columnMapping) (map[string]interface{}, error) {
+	str, err := msg.AsStructured()
+	if err != nil {
+		return nil, &invalidRowError{err: err}
+	}
+	row, err := mapping.apply(str)
+	if err != nil {
+		return nil, err
+	}
+	return convertRow(row, resolved)
+}
+
+func convertRow(row interface{}, resolved *resolvedDefinition) (map[string]interface{}, error) {
+	def := resolved.def
 	p, ok := row.(map[string]interface{})
 	if !ok {
-		return fmt.Errorf("unexpected message type %T", row)
+		return nil, &invalidRowError{err: fmt.Errorf("unexpected message type %T", row)}
 	}
 
 	dpPayload := make(map[string]interface{})
 
 	for _, dp := range def.DataProduct.DataPoints {
 
+		var err error
 		dpv, ok := p[dp.Name]
 		if (!ok || dpv == nil) && !dp.Optional {
-			return fmt.Errorf("missing required data point %v", dp.Name)
+			return nil, &invalidRowError{dataPoint: dp.Name, err: errors.New("missing required data point")}
 		}
 		if !ok || dpv == nil {
 			continue
 		}
+		var column *parquetschema.SchemaDefinition
 		if dp.Type == catalog.DPType_Array || dp.Type == catalog.DPType_Object {
-			nestedPayload, ok := dpv.(string)
-			if !ok {
-				return fmt.Errorf("nested data point %v should be of type jsob", dp.Name)
+			column = resolved.schemaDef.SubSchema(dp.Name)
+			if dpv, err = nestedValue(column, dp.Type == catalog.DPType_Array, dpv); err != nil {
+				return nil, &invalidRowError{dataPoint: dp.Name, err: err}
 			}
-			var nested interface{}
-			if err := json.Unmarshal([]byte(nestedPayload), &nested); err != nil {
-				return fmt.Errorf("nested data point %v should be of type jsob", dp.Name)
-			}
-			dpv = nested
+		} else if b, ok := dpv.([]byte); ok {
+			// Binary columns are decoded as bytes, they are converted
+			// like the text other columns are decoded as.
+			dpv = string(b)
 		}
 
-		pqv, err := catalog.ValidateAndConvertToParquetType(dpv, dp)
+		pqv, err := catalog.ValidateAndConvertToParquetType(floatNumbers(dpv), dp)
+		if err == nil {
+			err = setExactNumbers(column, dpv, pqv)
+		}
 		if err != nil {
-			return fmt.Errorf("data point %v err=(%v)", dp.Name, err)
+			rowErr := &invalidRowError{dataPoint: dp.Name, err: err}
+			if path, problem := findInvalidNested(column, dpv); problem != nil {
+				rowErr.path, rowErr.err = path, problem
+			}
+			return nil, rowErr
 		}
 		dpPayload[dp.Name] = pqv
 	}
 
-	if err := fw.AddData(dpPayload); err != nil {
-		return fmt.Errorf("error writing to parquet format %v", err)
-	}
-	return nil
+	return dpPayload, nil
 }
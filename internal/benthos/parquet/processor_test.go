@@ -3,8 +3,11 @@ package parquet
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
+	"reflect"
+	"sync"
 	"testing"
 
 	"github.com/benthosdev/benthos/v4/public/service"
@@ -57,6 +60,44 @@ func TestParquetProcessorValidationError(t *testing.T) {
 	}
 }
 
+func TestParquetProcessorBinaryColumns(t *testing.T) {
+	proc := newParquetProcessor(catalog.New("../../../testassets/datadefinitions"), "75d44fdc-dffd-42ea-af06-06fa4cb6fdbd", service.MockResources().Logger())
+
+	// Binary columns are decoded as bytes, text columns as strings, both
+	// convert the same way.
+	var rows []map[string]interface{}
+	for _, citizenID := range []interface{}{expectedCitizenID, []byte(expectedCitizenID)} {
+		msg := service.NewMessage(nil)
+		msg.SetStructured(map[string]interface{}{"citizen_id": citizenID})
+		result, err := proc.ProcessBatch(context.Background(), service.MessageBatch{msg})
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		payload, err := result[0][0].AsBytes()
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		fr, err := goparquet.NewFileReader(bytes.NewReader(payload))
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		row, err := fr.NextRow()
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		rows = append(rows, row)
+	}
+	if !reflect.DeepEqual(rows[0], rows[1]) {
+		t.Fatalf("Expected %v and %v to be written the same", rows[0], rows[1])
+	}
+
+	msg := service.NewMessage(nil)
+	msg.SetStructured(map[string]interface{}{"citizen_id": []byte("asdf")})
+	if _, err := proc.ProcessBatch(context.Background(), service.MessageBatch{msg}); err == nil {
+		t.Fatal("Expected error")
+	}
+}
+
 func TestParquetProcessorMissingMandatoryField(t *testing.T) {
 	proc := newParquetProcessor(catalog.New("../../../testassets/datadefinitions"), "75d44fdc-dffd-42ea-af06-06fa4cb6fdbd", service.MockResources().Logger())
 	_, err := proc.ProcessBatch(context.Background(), service.MessageBatch{service.NewMessage([]byte(`{"random": "asdf"}`))})
@@ -64,3 +105,488 @@ func TestParquetProcessorMissingMandatoryField(t *testing.T) {
 		t.Fatal("Expected error")
 	}
 }
+
+func TestParquetProcessorRollsFiles(t *testing.T) {
+	proc := newParquetProcessor(catalog.New("../../../testassets/datadefinitions"), "75d44fdc-dffd-42ea-af06-06fa4cb6fdbd", service.MockResources().Logger())
+	proc.format.(*parquetFormat).maxFileRows = 2
+
+	batch := service.MessageBatch{}
+	for i := 0; i < 5; i++ {
+		batch = append(batch, service.NewMessage([]byte(fmt.Sprintf(`{"citizen_id": "%s"}`, expectedCitizenID))))
+	}
+	result, err := proc.ProcessBatch(context.Background(), batch)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(result) != 1 || len(result[0]) != 3 {
+		t.Fatalf("Expected a batch of 3 files")
+	}
+
+	for i, expectedRows := range []int64{2, 2, 1} {
+		payload, err := result[0][i].AsBytes()
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		fr, err := goparquet.NewFileReader(bytes.NewReader(payload))
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if fr.NumRows() != expectedRows {
+			t.Fatalf("Expected file %v to have %v rows got %v", i, expectedRows, fr.NumRows())
+		}
+	}
+}
+
+// peakFormat records the peak size of the open file of the encoders of a format,
+// and whether every file had been written to the output of the processor once
+// it was emitted.
+type peakFormat struct {
+	fileFormat
+	written   func() int
+	emitted   int
+	unwritten int
+	peakOpen  int64
+}
+
+type peakEncoder struct {
+	*rollingWriter
+	f *peakFormat
+}
+
+func (f *peakFormat) newEncoder(resolved *resolvedDefinition, provenance Provenance, emit func([]byte) error) (rowEncoder, error) {
+	enc, err := f.fileFormat.newEncoder(resolved, provenance, func(file []byte) error {
+		if err := emit(file); err != nil {
+			return err
+		}
+		if f.emitted++; f.written() != f.emitted {
+			f.unwritten++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &peakEncoder{rollingWriter: enc.(*rollingWriter), f: f}, nil
+}
+
+func (e *peakEncoder) add(row map[string]interface{}) error {
+	if err := e.rollingWriter.add(row); err != nil {
+		return err
+	}
+	if open := e.buffered(); open > e.f.peakOpen {
+		e.f.peakOpen = open
+	}
+	return nil
+}
+
+// testFiles are the files written to uw_test_files outputs.
+var testFiles struct {
+	sync.Mutex
+	files [][]byte
+}
+
+type testFilesOutput struct{}
+
+func (testFilesOutput) Connect(ctx context.Context) error {
+	return nil
+}
+
+func (testFilesOutput) Write(ctx context.Context, msg *service.Message) error {
+	b, err := msg.AsBytes()
+	if err != nil {
+		return err
+	}
+	testFiles.Lock()
+	defer testFiles.Unlock()
+	testFiles.files = append(testFiles.files, b)
+	return nil
+}
+
+func (testFilesOutput) Close(ctx context.Context) error {
+	return nil
+}
+
+func init() {
+	if err := service.RegisterOutput("uw_test_files", service.NewConfigSpec(), func(conf *service.ParsedConfig, mgr *service.Resources) (service.Output, int, error) {
+		return testFilesOutput{}, 1, nil
+	}); err != nil {
+		panic(err)
+	}
+}
+
+func TestParquetProcessorStreamsFilesToOutput(t *testing.T) {
+	conf, err := processorConfig().ParseYAML(`
+dataProductID: 75d44fdc-dffd-42ea-af06-06fa4cb6fdbd
+row_group_size: 1024
+max_file_size: 4096
+output:
+  uw_test_files: {}
+`, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	proc, err := newParquetProcessorFromConfig(catalog.New("../../../testassets/datadefinitions"), conf, service.MockResources().Logger())
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	testFiles.Lock()
+	testFiles.files = nil
+	testFiles.Unlock()
+	format := &peakFormat{fileFormat: proc.format, written: func() int {
+		testFiles.Lock()
+		defer testFiles.Unlock()
+		return len(testFiles.files)
+	}}
+	proc.format = format
+
+	batch := service.MessageBatch{}
+	for i := 0; i < 10000; i++ {
+		batch = append(batch, service.NewMessage([]byte(fmt.Sprintf(`{"citizen_id": "75d44fdc-dffd-42ea-af06-%012d"}`, i))))
+	}
+	result, err := proc.ProcessBatch(context.Background(), batch)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if err := proc.Close(context.Background()); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	// Every file is written before the next rows, so only the open file
+	// and its row group are held whatever the size of the batch.
+	if len(result) > 0 && len(result[0]) > 0 {
+		t.Fatalf("Expected no files in the resulting batch got %v", len(result[0]))
+	}
+	if format.unwritten > 0 {
+		t.Fatalf("Expected every file to be written once closed, %v weren't", format.unwritten)
+	}
+	if max := int64(4096 + 1024); format.peakOpen > max {
+		t.Fatalf("Expected at most %v bytes of the open file to be held got %v", max, format.peakOpen)
+	}
+	var rows int64
+	for _, file := range testFiles.files {
+		fr, err := goparquet.NewFileReader(bytes.NewReader(file))
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		rows += fr.NumRows()
+	}
+	if rows != 10000 || len(testFiles.files) < 10 {
+		t.Fatalf("Expected 10000 rows in several files got %v rows in %v files", rows, len(testFiles.files))
+	}
+}
+
+func TestParquetProcessorWriterOptionsConfig(t *testing.T) {
+	for _, conf := range []string{
+		`{"dataProductID": "id", "data_page_version": 3}`,
+		`{"dataProductID": "id", "page_size": 0}`,
+		`{"dataProductID": "id", "compression": "snappy", "compression_level": 3}`,
+	} {
+		parsed, err := processorConfig().ParseYAML(conf, nil)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if _, err := newParquetProcessorFromConfig(nil, parsed, nil); err == nil {
+			t.Fatalf("Expected error for %v", conf)
+		}
+	}
+}
+
+func TestParquetProcessorRejects(t *testing.T) {
+	for _, format := range []string{"jsonl", "parquet"} {
+		conf, err := processorConfig().ParseYAML(fmt.Sprintf(`
+dataProductID: 75d44fdc-dffd-42ea-af06-06fa4cb6fdbd
+rejects:
+  path: rejects.%v
+  format: %v
+`, format, format), nil)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		proc, err := newParquetProcessorFromConfig(catalog.New("../../../testassets/datadefinitions"), conf, service.MockResources().Logger())
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+
+		result, err := proc.ProcessBatch(context.Background(), service.MessageBatch{
+			service.NewMessage([]byte(fmt.Sprintf(`{"citizen_id": "%s"}`, expectedCitizenID))),
+			service.NewMessage([]byte(`{"citizen_id": "asdf"}`)),
+			service.NewMessage([]byte(`{"random": "asdf"}`)),
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if len(result) != 1 || len(result[0]) != 2 {
+			t.Fatalf("Expected a batch of a parquet file and a rejects file")
+		}
+
+		payload, err := result[0][0].AsBytes()
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		fr, err := goparquet.NewFileReader(bytes.NewReader(payload))
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if fr.NumRows() != 1 {
+			t.Fatalf("Expected 1 valid row got %v", fr.NumRows())
+		}
+
+		rejectsMsg := result[0][1]
+		if path, _ := rejectsMsg.MetaGet(rejectsPathMeta); path != "rejects."+format {
+			t.Fatalf("Expected rejects path rejects.%v got %v", format, path)
+		}
+		payload, err = rejectsMsg.AsBytes()
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+
+		var rejects []map[string]string
+		if format == "jsonl" {
+			dec := json.NewDecoder(bytes.NewReader(payload))
+			for dec.More() {
+				var r struct {
+					DataPoint string          `json:"data_point"`
+					Error     string          `json:"error"`
+					Row       json.RawMessage `json:"row"`
+				}
+				if err := dec.Decode(&r); err != nil {
+					t.Fatalf("Unexpected error %v", err)
+				}
+				rejects = append(rejects, map[string]string{"data_point": r.DataPoint, "error": r.Error, "row": string(r.Row)})
+			}
+		} else {
+			fr, err := goparquet.NewFileReader(bytes.NewReader(payload))
+			if err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			for {
+				row, err := fr.NextRow()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("Unexpected error %v", err)
+				}
+				rejects = append(rejects, map[string]string{"data_point": string(row["data_point"].([]byte)), "error": string(row["error"].([]byte)), "row": string(row["row"].([]byte))})
+			}
+		}
+
+		if len(rejects) != 2 {
+			t.Fatalf("Expected 2 rejects got %v", rejects)
+		}
+		for i, expectedRow := range []string{`{"citizen_id":"asdf"}`, `{"random":"asdf"}`} {
+			if rejects[i]["data_point"] != "citizen_id" || rejects[i]["error"] == "" || rejects[i]["row"] != expectedRow {
+				t.Fatalf("Unexpected reject %v", rejects[i])
+			}
+		}
+	}
+}
+
+func TestParquetProcessorErrorBudget(t *testing.T) {
+	conf, err := processorConfig().ParseYAML(`
+dataProductID: 75d44fdc-dffd-42ea-af06-06fa4cb6fdbd
+max_invalid_rows: 1
+`, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	proc, err := newParquetProcessorFromConfig(catalog.New("../../../testassets/datadefinitions"), conf, service.MockResources().Logger())
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	batch := func() service.MessageBatch {
+		return service.MessageBatch{
+			service.NewMessage([]byte(fmt.Sprintf(`{"citizen_id": "%s"}`, expectedCitizenID))),
+			service.NewMessage([]byte(`{"citizen_id": "asdf"}`)),
+		}
+	}
+
+	result, err := proc.ProcessBatch(context.Background(), batch())
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(result) != 1 || len(result[0]) != 1 {
+		t.Fatalf("Expected a batch of a single file")
+	}
+	payload, err := result[0][0].AsBytes()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	fr, err := goparquet.NewFileReader(bytes.NewReader(payload))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if fr.NumRows() != 1 {
+		t.Fatalf("Expected 1 valid row got %v", fr.NumRows())
+	}
+
+	// The budget is shared by the batches of the run.
+	if _, err := proc.ProcessBatch(context.Background(), batch()); err == nil {
+		t.Fatal("Expected error")
+	}
+}
+
+func TestParquetProcessorProvenance(t *testing.T) {
+	conf, err := processorConfig().ParseYAML(`
+dataProductID: 75d44fdc-dffd-42ea-af06-06fa4cb6fdbd
+max_file_rows: 2
+provenance:
+  run_id: "1656000000"
+  source_driver: postgres
+  source_query: select * from consents
+`, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	proc, err := newParquetProcessorFromConfig(catalog.New("../../../testassets/datadefinitions"), conf, service.MockResources().Logger())
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	proc.provenance.GitHash = "abc123"
+
+	batch := service.MessageBatch{}
+	for i := 0; i < 3; i++ {
+		batch = append(batch, service.NewMessage([]byte(fmt.Sprintf(`{"citizen_id": "%s"}`, expectedCitizenID))))
+	}
+	result, err := proc.ProcessBatch(context.Background(), batch)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(result) != 1 || len(result[0]) != 2 {
+		t.Fatalf("Expected a batch of 2 files")
+	}
+
+	for i, expectedRows := range []int64{2, 1} {
+		payload, err := result[0][i].AsBytes()
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		p, err := ReadProvenance(bytes.NewReader(payload))
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if p.DataProductID != "75d44fdc-dffd-42ea-af06-06fa4cb6fdbd" || p.DataProductFQN != "caps.v1.consent_and_preference" ||
+			len(p.SchemaHash) != 64 || p.RunID != "1656000000" || p.GitHash != "abc123" || p.RowCount != expectedRows ||
+			p.SourceDriver != "postgres" || p.SourceQuery != "select * from consents" {
+			t.Fatalf("Unexpected provenance %+v", p)
+		}
+	}
+}
+
+func TestParquetProcessorProvenanceBuiltQuery(t *testing.T) {
+	conf, err := processorConfig().ParseYAML(`
+dataProductID: 75d44fdc-dffd-42ea-af06-06fa4cb6fdbd
+provenance:
+  source_driver: postgres
+`, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	cat := catalog.New("../../../testassets/datadefinitions")
+	proc, err := newParquetProcessorFromConfig(cat, conf, service.MockResources().Logger())
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	// Without QUERY the input builds the query from the definition and sets
+	// it as metadata of every row.
+	query, err := QueryBuilder(cat)("75d44fdc-dffd-42ea-af06-06fa4cb6fdbd", "public.consents", nil)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	msg := service.NewMessage([]byte(fmt.Sprintf(`{"citizen_id": "%s"}`, expectedCitizenID)))
+	msg.MetaSet("sql_source_query", query)
+	result, err := proc.ProcessBatch(context.Background(), service.MessageBatch{msg})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	payload, err := result[0][0].AsBytes()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	p, err := ReadProvenance(bytes.NewReader(payload))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if p.SourceQuery != query || p.SourceDriver != "postgres" {
+		t.Fatalf("Unexpected provenance %+v", p)
+	}
+
+	// Rows of other inputs have no source query.
+	result, err = proc.ProcessBatch(context.Background(), service.MessageBatch{service.NewMessage([]byte(fmt.Sprintf(`{"citizen_id": "%s"}`, expectedCitizenID)))})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if payload, err = result[0][0].AsBytes(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if p, err = ReadProvenance(bytes.NewReader(payload)); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if p.SourceQuery != "" {
+		t.Fatalf("Expected no source query got %v", p.SourceQuery)
+	}
+}
+
+func TestParquetProcessorWorkers(t *testing.T) {
+	proc := newParquetProcessor(catalog.New("../../../testassets/datadefinitions"), "75d44fdc-dffd-42ea-af06-06fa4cb6fdbd", service.MockResources().Logger())
+	proc.workers = 4
+
+	var expectedIDs []string
+	batch := service.MessageBatch{}
+	for i := 0; i < 1000; i++ {
+		id := fmt.Sprintf("75d44fdc-dffd-42ea-af06-%012d", i)
+		expectedIDs = append(expectedIDs, id)
+		batch = append(batch, service.NewMessage([]byte(fmt.Sprintf(`{"citizen_id": "%s", "consent_references": "[{\"consent_reference\": \"email-%d\", \"is_consented\": true}]"}`, id, i))))
+	}
+	result, err := proc.ProcessBatch(context.Background(), batch)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	payload, err := result[0][0].AsBytes()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	fr, err := goparquet.NewFileReader(bytes.NewReader(payload))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	for i, expectedID := range expectedIDs {
+		row, err := fr.NextRow()
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if citizenID := fmt.Sprintf("%s", row["citizen_id"]); citizenID != expectedID {
+			t.Fatalf("Expected row %v to be %v got %v", i, expectedID, citizenID)
+		}
+	}
+
+	// The first invalid row of the batch fails it.
+	batch[10] = service.NewMessage([]byte(`{"citizen_id": "asdf"}`))
+	batch[900] = service.NewMessage([]byte(`{"random": "asdf"}`))
+	_, err = proc.ProcessBatch(context.Background(), batch)
+	if err == nil || err.Error() != "data point citizen_id err=(invalid UUID length: 4)" {
+		t.Fatalf("Expected error of row 10 got %v", err)
+	}
+}
+
+func TestParquetProcessorBloomFilterColumns(t *testing.T) {
+	for conf, valid := range map[string]bool{
+		`bloom_filter_columns: [citizen_id]`: true,
+		`bloom_filter_columns: [missing]`:    false,
+	} {
+		parsed, err := processorConfig().ParseYAML("dataProductID: 75d44fdc-dffd-42ea-af06-06fa4cb6fdbd\n"+conf, nil)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		_, err = newParquetProcessorFromConfig(catalog.New("../../../testassets/datadefinitions"), parsed, service.MockResources().Logger())
+		if valid && err != nil {
+			t.Fatalf("Unexpected error %v for %v", err, conf)
+		}
+		if !valid && err == nil {
+			t.Fatalf("Expected error for %v", conf)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package parquet
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/benthosdev/benthos/v4/public/service"
+	goparquet "github.com/fraugster/parquet-go"
+	"github.com/fraugster/parquet-go/parquetschema"
+)
+
+// Key/value metadata keys of the provenance of a file.
+const (
+	metaDataProductID  = "uw.data_product.id"
+	metaDataProductFQN = "uw.data_product.fqn"
+	metaSchemaHash     = "uw.schema.sha256"
+	metaRunID          = "uw.run_id"
+	metaGitHash        = "uw.git_hash"
+	metaRowCount       = "uw.row_count"
+	metaSourceDriver   = "uw.source.driver"
+	metaSourceQuery    = "uw.source.query"
+)
+
+// Provenance describes what produced a parquet file, it is written to the key
+// value metadata of every file.
+type Provenance struct {
+	DataProductID  string `json:"data_product_id"`
+	DataProductFQN string `json:"data_product_fqn"`
+	SchemaHash     string `json:"schema_hash"`
+	RunID          string `json:"run_id"`
+	GitHash        string `json:"git_hash"`
+	RowCount       int64  `json:"row_count"`
+	SourceDriver   string `json:"source_driver"`
+	SourceQuery    string `json:"source_query"`
+}
+
+func provenanceField() *service.ConfigField {
+	return service.NewObjectField("provenance",
+		service.NewStringField("run_id").
+			Description("An id of the run producing the files.").
+			Default("").
+			Example("${CREATED_AT}"),
+		service.NewStringField("source_driver").
+			Description("The driver of the source the rows are read from.").
+			Default("").
+			Example("${DRIVER}"),
+		service.NewInterpolatedStringField("source_query").
+			Description("The query the rows are read with, it is evaluated against the first message of each batch. By default it is the `sql_source_query` metadata the `uw_sql_raw` and `uw_sql_snapshot` inputs set to the query that ran, which is also the query built from the data product.").
+			Default(`${! meta("sql_source_query").or("") }`).
+			Example("${QUERY}"),
+	).
+		Description("Details of the run written to the key value metadata of every file, along with the data product id and fqn, a hash of the parquet schema, the git hash of the binary and the row count of the file.").
+		Advanced()
+}
+
+// provenanceFromParsed returns the provenance of the run along with the source
+// query, which is evaluated for every batch.
+func provenanceFromParsed(conf *service.ParsedConfig) (p Provenance, sourceQuery *service.InterpolatedString, err error) {
+	if p.RunID, err = conf.FieldString("run_id"); err != nil {
+		return
+	}
+	if p.SourceDriver, err = conf.FieldString("source_driver"); err != nil {
+		return
+	}
+	sourceQuery, err = conf.FieldInterpolatedString("source_query")
+	return
+}
+
+// schemaHash returns the hex encoded sha256 of a parquet schema.
+func schemaHash(schemaDef *parquetschema.SchemaDefinition) string {
+	sum := sha256.Sum256([]byte(schemaDef.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+// metadata returns the key value metadata of the provenance, the row count is
+// set when a file is closed.
+func (p Provenance) metadata() map[string]string {
+	return map[string]string{
+		metaDataProductID:  p.DataProductID,
+		metaDataProductFQN: p.DataProductFQN,
+		metaSchemaHash:     p.SchemaHash,
+		metaRunID:          p.RunID,
+		metaGitHash:        p.GitHash,
+		metaSourceDriver:   p.SourceDriver,
+		metaSourceQuery:    p.SourceQuery,
+	}
+}
+
+// ReadProvenance reads the provenance from the key value metadata of a parquet
+// file.
+func ReadProvenance(r io.ReadSeeker) (*Provenance, error) {
+	fr, err := goparquet.NewFileReader(r)
+	if err != nil {
+		return nil, err
+	}
+	kv := fr.MetaData()
+	if _, ok := kv[metaDataProductID]; !ok {
+		return nil, fmt.Errorf("file has no provenance metadata")
+	}
+
+	p := &Provenance{
+		DataProductID:  kv[metaDataProductID],
+		DataProductFQN: kv[metaDataProductFQN],
+		SchemaHash:     kv[metaSchemaHash],
+		RunID:          kv[metaRunID],
+		GitHash:        kv[metaGitHash],
+		SourceDriver:   kv[metaSourceDriver],
+		SourceQuery:    kv[metaSourceQuery],
+	}
+	if rows, ok := kv[metaRowCount]; ok {
+		if p.RowCount, err = strconv.ParseInt(rows, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid %v %v", metaRowCount, err)
+		}
+	}
+	return p, nil
+}
@@ -0,0 +1,205 @@
+package parquet
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/utilitywarehouse/data-infra-pg-source/internal/benthos/dataproduct"
+	"github.com/utilitywarehouse/data-products-definitions/pkg/catalog/v1"
+)
+
+// postgresType is the postgres type data points of a type are cast to, and
+// the types of the columns they can be read from.
+type postgresType struct {
+	cast    string
+	columns []string
+	// Nested data points are also read from native arrays or composite
+	// types, which are selected as is.
+	arrays, composites bool
+}
+
+// postgresTypes are the postgres types of data points, by data point type.
+// Timestamps aren't cast, as casting timestamptz to timestamp converts it
+// with the session TimeZone.
+var postgresTypes = map[string]postgresType{
+	"BOOLEAN":    {cast: "boolean", columns: []string{"bool"}},
+	"INT":        {cast: "bigint", columns: []string{"int2", "int4", "int8"}},
+	"FLOAT":      {cast: "double precision", columns: []string{"float4", "float8"}},
+	"DOUBLE":     {cast: "double precision", columns: []string{"float4", "float8"}},
+	"DECIMAL":    {cast: "numeric(18,2)", columns: []string{"numeric"}},
+	"STRING":     {cast: "text", columns: []string{"text", "varchar", "bpchar"}},
+	"UUID":       {cast: "text", columns: []string{"uuid", "text", "varchar"}},
+	"DATE":       {cast: "date", columns: []string{"date"}},
+	"TIMESTAMP":  {columns: []string{"timestamp", "timestamptz"}},
+	"BYTE_ARRAY": {cast: "bytea", columns: []string{"bytea"}},
+	"ARRAY":      {columns: []string{"jsonb", "json"}, arrays: true},
+	"OBJECT":     {columns: []string{"jsonb", "json"}, composites: true},
+}
+
+func postgresTypeOf(dataPoint string, dpType interface{}) (postgresType, error) {
+	t, ok := postgresTypes[strings.ToUpper(fmt.Sprint(dpType))]
+	if !ok {
+		return postgresType{}, fmt.Errorf("data point %v has unsupported type %v", dataPoint, dpType)
+	}
+	return t, nil
+}
+
+// reads returns whether a data point can be read from a column of a type.
+func (t postgresType) reads(column string) bool {
+	return containsString(t.columns, column) ||
+		t.arrays && strings.HasPrefix(column, "_") ||
+		t.composites && column == "record"
+}
+
+func (t postgresType) String() string {
+	s := strings.Join(t.columns, " or ")
+	switch {
+	case t.arrays:
+		s += " or an array"
+	case t.composites:
+		s += " or a composite type"
+	}
+	return s
+}
+
+// SelectQuery returns a postgres query selecting the data points of a
+// definition from a table, cast to the types they are validated against.
+// Nested data points are selected as is, whether they are json, arrays or
+// composite types, and so are timestamps. Mapped data points are selected
+// from their source column under its own name, so that the processor maps
+// them, and json columns of mapped paths as is.
+func SelectQuery(def *catalog.Definition, table string, mapping []dataproduct.Mapping) (string, error) {
+	if len(def.DataProduct.DataPoints) == 0 {
+		return "", fmt.Errorf("data product %v has no data points", def.DataProduct.ID)
+	}
+	mapped := mappingsByDataPoint(mapping)
+	selected := map[string]bool{}
+	columns := make([]string, 0, len(def.DataProduct.DataPoints))
+	for _, dp := range def.DataProduct.DataPoints {
+		pgType, err := postgresTypeOf(dp.Name, dp.Type)
+		if err != nil {
+			return "", err
+		}
+		column, cast := dp.Name, pgType.cast
+		if m, ok := mapped[dp.Name]; ok {
+			column = m.Column
+			if len(m.Path) > 0 {
+				cast = ""
+			}
+		}
+		if selected[column] {
+			continue
+		}
+		selected[column] = true
+
+		name := quoteIdentifier(column)
+		if cast == "" {
+			columns = append(columns, name)
+			continue
+		}
+		columns = append(columns, fmt.Sprintf("%v::%v AS %v", name, cast, name))
+	}
+
+	parts := strings.Split(table, ".")
+	for i, p := range parts {
+		parts[i] = quoteIdentifier(p)
+	}
+	return fmt.Sprintf("SELECT %v FROM %v", strings.Join(columns, ", "), strings.Join(parts, ".")), nil
+}
+
+// QueryBuilder returns a function building the query of a data product with
+// SelectQuery, for the uw_sql_raw input.
+func QueryBuilder(cat Definitions) func(dataProductID, table string, mapping []dataproduct.Mapping) (string, error) {
+	return func(dataProductID, table string, mapping []dataproduct.Mapping) (string, error) {
+		def, err := cat.GetByID(dataProductID)
+		if err != nil {
+			return "", fmt.Errorf("could not find data product with id %v err=%v", dataProductID, err)
+		}
+		return SelectQuery(def, table, mapping)
+	}
+}
+
+// CheckColumns returns an error listing every data point of a definition whose
+// column is missing, of a type it can't be read from, or nullable while the
+// data point is required. Nullability is only checked when known. Mapped data
+// points are checked against their source column, which must be json if a
+// path inside it is mapped. Values inside json columns are only validated as
+// rows are processed.
+func CheckColumns(def *catalog.Definition, columns []dataproduct.Column, mapping []dataproduct.Mapping) error {
+	byName := make(map[string]dataproduct.Column, len(columns))
+	for _, c := range columns {
+		byName[c.Name] = c
+	}
+	mapped := mappingsByDataPoint(mapping)
+
+	var problems []string
+	for _, dp := range def.DataProduct.DataPoints {
+		pgType, err := postgresTypeOf(dp.Name, dp.Type)
+		if err != nil {
+			return err
+		}
+		m, isMapped := mapped[dp.Name]
+		column := dp.Name
+		if isMapped {
+			column = m.Column
+		}
+		c, ok := byName[column]
+		if !ok {
+			if isMapped {
+				problems = append(problems, fmt.Sprintf("%v: missing column %v", dp.Name, column))
+			} else {
+				problems = append(problems, fmt.Sprintf("%v: missing column", dp.Name))
+			}
+			continue
+		}
+		if isMapped && len(m.Path) > 0 {
+			if c.Type != "json" && c.Type != "jsonb" {
+				problems = append(problems, fmt.Sprintf("%v: expected json or jsonb column %v, got %v", dp.Name, column, c.Type))
+			}
+			continue
+		}
+		if !pgType.reads(c.Type) {
+			problems = append(problems, fmt.Sprintf("%v: expected %v, got %v", dp.Name, pgType, c.Type))
+		}
+		if !dp.Optional && !m.HasDefault && c.Nullable != nil && *c.Nullable {
+			problems = append(problems, fmt.Sprintf("%v: nullable column for a required data point", dp.Name))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("columns of the source don't match data product %v:\n  - %v", def.DataProduct.ID, strings.Join(problems, "\n  - "))
+	}
+	return nil
+}
+
+// ColumnChecker returns a function checking the columns of a data product with
+// CheckColumns, for the uw_sql_raw input.
+func ColumnChecker(cat Definitions) func(dataProductID string, columns []dataproduct.Column, mapping []dataproduct.Mapping) error {
+	return func(dataProductID string, columns []dataproduct.Column, mapping []dataproduct.Mapping) error {
+		def, err := cat.GetByID(dataProductID)
+		if err != nil {
+			return fmt.Errorf("could not find data product with id %v err=%v", dataProductID, err)
+		}
+		return CheckColumns(def, columns, mapping)
+	}
+}
+
+func mappingsByDataPoint(mapping []dataproduct.Mapping) map[string]dataproduct.Mapping {
+	byDataPoint := make(map[string]dataproduct.Mapping, len(mapping))
+	for _, m := range mapping {
+		byDataPoint[m.DataPoint] = m
+	}
+	return byDataPoint
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
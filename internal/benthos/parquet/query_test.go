@@ -0,0 +1,99 @@
+package parquet
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/utilitywarehouse/data-infra-pg-source/internal/benthos/dataproduct"
+	"github.com/utilitywarehouse/data-products-definitions/pkg/catalog/v1"
+)
+
+func TestSelectQuery(t *testing.T) {
+	buildQuery := QueryBuilder(catalog.New("../../../testassets/datadefinitions"))
+	query, err := buildQuery("75d44fdc-dffd-42ea-af06-06fa4cb6fdbd", "public.consents", nil)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	expected := `SELECT "citizen_id"::text AS "citizen_id", "consent_references" FROM "public"."consents"`
+	if query != expected {
+		t.Fatalf("Expected %v got %v", expected, query)
+	}
+
+	if _, err := buildQuery("unknown", "public.consents", nil); err == nil {
+		t.Fatal("Expected error")
+	}
+}
+
+func TestCheckColumns(t *testing.T) {
+	def, err := catalog.New("../../../testassets/datadefinitions").GetByID("75d44fdc-dffd-42ea-af06-06fa4cb6fdbd")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	nullable, required := true, false
+
+	err = CheckColumns(def, []dataproduct.Column{
+		{Name: "citizen_id", Type: "uuid", Nullable: &required},
+		{Name: "consent_references", Type: "jsonb", Nullable: &nullable},
+		{Name: "other", Type: "text"},
+	}, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	err = CheckColumns(def, []dataproduct.Column{
+		{Name: "citizen_id", Type: "text"},
+		{Name: "consent_references", Type: "_consent_reference"},
+	}, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	err = CheckColumns(def, []dataproduct.Column{
+		{Name: "citizen_id", Type: "int8", Nullable: &nullable},
+	}, nil)
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+	for _, problem := range []string{
+		"citizen_id: expected uuid or text or varchar, got int8",
+		"citizen_id: nullable column for a required data point",
+		"consent_references: missing column",
+	} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Fatalf("Expected %q in %v", problem, err)
+		}
+	}
+}
+
+func TestSelectQueryTimestamps(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "events.dd.yaml"), []byte(`definition:
+  id: 0f5d3c8e-6a1b-4d2f-9c7e-3b8a1e2d4f60
+  fqn: test.v1.events
+  description: "Events"
+  dataPoints:
+    - name: id
+      type: INT
+      description: "The id of the event."
+      optional: false
+    - name: created_at
+      type: TIMESTAMP
+      description: "When the event happened."
+      optional: false
+`), 0o644); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	// Casting a timestamptz column to timestamp would convert it with the
+	// session TimeZone, so timestamps are selected as is.
+	query, err := QueryBuilder(catalog.New(dir))("0f5d3c8e-6a1b-4d2f-9c7e-3b8a1e2d4f60", "events", nil)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	expected := `SELECT "id"::bigint AS "id", "created_at" FROM "events"`
+	if query != expected {
+		t.Fatalf("Expected %v got %v", expected, query)
+	}
+}
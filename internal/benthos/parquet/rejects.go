@@ -0,0 +1,165 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/benthosdev/benthos/v4/public/service"
+	goparquet "github.com/fraugster/parquet-go"
+	"github.com/fraugster/parquet-go/parquet"
+	"github.com/fraugster/parquet-go/parquetschema"
+)
+
+// rejectsPathMeta is the metadata key of the path a rejects file should be
+// written to.
+const rejectsPathMeta = "uw_parquet_rejects_path"
+
+const rejectsSchema = `message rejects {
+	optional binary data_point (STRING);
+	optional binary path (STRING);
+	required binary error (STRING);
+	required binary row (STRING);
+}`
+
+func rejectsField() *service.ConfigField {
+	return service.NewObjectField("rejects",
+		service.NewInterpolatedStringField("path").
+			Description("The path the rejects file should be written to, it is set as the `uw_parquet_rejects_path` metadata of the rejects message so that outputs can route it.").
+			Example(`rejects/${!timestamp_unix()}.jsonl`),
+		service.NewStringEnumField("format", "jsonl", "parquet").
+			Description("The format of the rejects file. Each reject has the `data_point` that failed validation, if any, the JSON `path` of the invalid value of a nested data point, e.g. `consent_references[3].expires_at`, the `error` and the original `row` as JSON.").
+			Default("jsonl"),
+	).
+		Description("Diverts rows that fail validation into a rejects file instead of failing the whole batch. The rejects file is emitted as the last message of the resulting batch, after the files of the valid rows unless they are written to `output`.").
+		Optional()
+}
+
+type rejectsConfig struct {
+	path   *service.InterpolatedString
+	format string
+}
+
+func rejectsConfigFromParsed(conf *service.ParsedConfig) (*rejectsConfig, error) {
+	path, err := conf.FieldInterpolatedString("path")
+	if err != nil {
+		return nil, err
+	}
+	format, err := conf.FieldString("format")
+	if err != nil {
+		return nil, err
+	}
+	return &rejectsConfig{path: path, format: format}, nil
+}
+
+// invalidRowError is returned for rows that fail validation, as opposed to
+// errors writing valid rows.
+type invalidRowError struct {
+	dataPoint string
+	// path is the path of the invalid value inside a nested data point, e.g.
+	// [3].expires_at.
+	path string
+	err  error
+}
+
+func (e *invalidRowError) Error() string {
+	if e.dataPoint == "" {
+		return e.err.Error()
+	}
+	return fmt.Sprintf("data point %v%v err=(%v)", e.dataPoint, e.path, e.err)
+}
+
+func (e *invalidRowError) Unwrap() error {
+	return e.err
+}
+
+type reject struct {
+	DataPoint string          `json:"data_point,omitempty"`
+	Path      string          `json:"path,omitempty"`
+	Error     string          `json:"error"`
+	Row       json.RawMessage `json:"row"`
+}
+
+func newReject(rowErr *invalidRowError, msg *service.Message) (reject, error) {
+	b, err := msg.AsBytes()
+	if err != nil {
+		return reject{}, err
+	}
+	// Rows that aren't JSON are kept as a JSON string.
+	row := &bytes.Buffer{}
+	if err := json.Compact(row, b); err != nil {
+		if b, err = json.Marshal(string(b)); err != nil {
+			return reject{}, err
+		}
+		row.Reset()
+		row.Write(b)
+	}
+	r := reject{DataPoint: rowErr.dataPoint, Error: rowErr.err.Error(), Row: row.Bytes()}
+	if rowErr.path != "" {
+		r.Path = rowErr.dataPoint + rowErr.path
+	}
+	return r, nil
+}
+
+// newMessage encodes the rejects into a message of the rejects file.
+func (c *rejectsConfig) newMessage(rejects []reject) (*service.Message, error) {
+	var b []byte
+	var err error
+	switch c.format {
+	case "parquet":
+		b, err = encodeRejectsParquet(rejects)
+	default:
+		b, err = encodeRejectsJSONL(rejects)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error writing rejects %v", err)
+	}
+
+	msg := service.NewMessage(b)
+	msg.MetaSet(rejectsPathMeta, c.path.String(msg))
+	return msg, nil
+}
+
+func encodeRejectsJSONL(rejects []reject) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	for _, r := range rejects {
+		if err := enc.Encode(r); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func encodeRejectsParquet(rejects []reject) ([]byte, error) {
+	schemaDef, err := parquetschema.ParseSchemaDefinition(rejectsSchema)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	fw := goparquet.NewFileWriter(buf,
+		goparquet.WithSchemaDefinition(schemaDef),
+		goparquet.WithCompressionCodec(parquet.CompressionCodec_SNAPPY),
+		goparquet.WithCreator("write-lowlevel"),
+	)
+	for _, r := range rejects {
+		row := map[string]interface{}{
+			"error": []byte(r.Error),
+			"row":   []byte(r.Row),
+		}
+		if r.DataPoint != "" {
+			row["data_point"] = []byte(r.DataPoint)
+		}
+		if r.Path != "" {
+			row["path"] = []byte(r.Path)
+		}
+		if err := fw.AddData(row); err != nil {
+			return nil, err
+		}
+	}
+	if err := fw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
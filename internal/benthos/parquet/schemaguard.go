@@ -0,0 +1,357 @@
+package parquet
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	"github.com/benthosdev/benthos/v4/public/service"
+	goparquet "github.com/fraugster/parquet-go"
+	"github.com/fraugster/parquet-go/parquet"
+	"github.com/fraugster/parquet-go/parquetschema"
+)
+
+func schemaGuardField() *service.ConfigField {
+	return service.NewObjectField("schema_guard",
+		service.NewStringField("previous_schema").
+			Description("The path of the last published parquet file, or of a schema record in the parquet schema text format, either local or a `gs://bucket/object` URL. A missing `gs://` object, e.g. before the first publish, is not checked. When empty the schema is not checked.").
+			Default("").
+			Example("${PREVIOUS_SCHEMA:}").
+			Example("gs://bucket/schemas/75d44fdc-dffd-42ea-af06-06fa4cb6fdbd.parquetschema"),
+		service.NewStringField("record_path").
+			Description("The path a schema record of the published schema is written to, either local or a `gs://bucket/object` URL, so that the next run is checked against it when it reads it as `previous_schema`. The record is written once when the processor closes, and only if files were published and no batch failed. When empty no record is written.").
+			Default("").
+			Example("gs://bucket/schemas/75d44fdc-dffd-42ea-af06-06fa4cb6fdbd.parquetschema"),
+		service.NewBoolField("allow_breaking_changes").
+			Description("Publish files even if the schema has breaking changes.").
+			Default(false),
+	).
+		Description("Compares the parquet schema of the data product against a previously published one. Added optional data points are compatible, while removed data points, type changes and optional data points becoming required are breaking changes which fail processing, unless explicitly allowed.").
+		Optional()
+}
+
+type schemaGuard struct {
+	// previous is nil if there is no previously published schema.
+	previous      *parquetschema.SchemaDefinition
+	allowBreaking bool
+	recordPath    string
+
+	mu sync.Mutex
+	// published is the schema of the published files, nil until a batch
+	// publishes files.
+	published *parquetschema.SchemaDefinition
+	failed    bool
+}
+
+func schemaGuardFromParsed(conf *service.ParsedConfig) (*schemaGuard, error) {
+	path, err := conf.FieldString("previous_schema")
+	if err != nil {
+		return nil, err
+	}
+	allowBreaking, err := conf.FieldBool("allow_breaking_changes")
+	if err != nil {
+		return nil, err
+	}
+	recordPath, err := conf.FieldString("record_path")
+	if err != nil {
+		return nil, err
+	}
+	if path == "" && recordPath == "" {
+		return nil, nil
+	}
+	g := &schemaGuard{allowBreaking: allowBreaking, recordPath: recordPath}
+	if path != "" {
+		if g.previous, err = readSchema(path); err != nil {
+			return nil, fmt.Errorf("could not read previous schema %v err=%v", path, err)
+		}
+	}
+	return g, nil
+}
+
+// readSchema reads the schema of a parquet file, or a schema record, which is
+// nil if a gs:// object doesn't exist.
+func readSchema(path string) (*parquetschema.SchemaDefinition, error) {
+	if strings.HasPrefix(path, "gs://") {
+		b, err := readGCSObject(context.Background(), path)
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return parseSchema(bytes.NewReader(b))
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseSchema(f)
+}
+
+func readGCSObject(ctx context.Context, url string) ([]byte, error) {
+	bucket, object, ok := strings.Cut(strings.TrimPrefix(url, "gs://"), "/")
+	if !ok || bucket == "" || object == "" {
+		return nil, fmt.Errorf("invalid gs:// URL %v, expected gs://bucket/object", url)
+	}
+
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	r, err := client.Bucket(bucket).Object(object).NewReader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func writeGCSObject(ctx context.Context, url string, b []byte) error {
+	bucket, object, ok := strings.Cut(strings.TrimPrefix(url, "gs://"), "/")
+	if !ok || bucket == "" || object == "" {
+		return fmt.Errorf("invalid gs:// URL %v, expected gs://bucket/object", url)
+	}
+
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	w := client.Bucket(bucket).Object(object).NewWriter(ctx)
+	if _, err := w.Write(b); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func parseSchema(f io.ReadSeeker) (*parquetschema.SchemaDefinition, error) {
+	magic := make([]byte, len(parquetMagic))
+	if _, err := io.ReadFull(f, magic); err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if bytes.Equal(magic, parquetMagic) {
+		fr, err := goparquet.NewFileReader(f)
+		if err != nil {
+			return nil, err
+		}
+		return fr.GetSchemaDefinition(), nil
+	}
+
+	record, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	return parquetschema.ParseSchemaDefinition(string(record))
+}
+
+// check returns an error if the schema has breaking changes that aren't
+// allowed, other changes are logged.
+func (g *schemaGuard) check(current *parquetschema.SchemaDefinition, logger *service.Logger) error {
+	if g.previous == nil {
+		return nil
+	}
+	var breaking []string
+	for _, c := range compareSchemas(g.previous, current) {
+		if !c.breaking {
+			logger.Infof("compatible schema change %v", c)
+			continue
+		}
+		if g.allowBreaking {
+			logger.Warnf("allowed breaking schema change %v", c)
+			continue
+		}
+		breaking = append(breaking, c.String())
+	}
+	if len(breaking) > 0 {
+		return fmt.Errorf("breaking schema changes, set schema_guard.allow_breaking_changes to publish them: %v", strings.Join(breaking, "; "))
+	}
+	return nil
+}
+
+// batchDone records the outcome of a batch, published is the schema of its
+// files or nil if it has none.
+func (g *schemaGuard) batchDone(published *parquetschema.SchemaDefinition, err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err != nil {
+		g.failed = true
+		return
+	}
+	if published != nil {
+		g.published = published
+	}
+}
+
+// writeRecord writes a schema record of the published schema to the
+// record_path, unless no files were published or a batch failed, so that a
+// failed run is never checked against by the next one.
+func (g *schemaGuard) writeRecord(ctx context.Context) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.recordPath == "" || g.published == nil || g.failed {
+		return nil
+	}
+	record := []byte(g.published.String())
+	var err error
+	if strings.HasPrefix(g.recordPath, "gs://") {
+		err = writeGCSObject(ctx, g.recordPath, record)
+	} else {
+		err = os.WriteFile(g.recordPath, record, 0o644)
+	}
+	if err != nil {
+		return fmt.Errorf("could not write schema record %v err=%v", g.recordPath, err)
+	}
+	// A record is written once per run.
+	g.published = nil
+	return nil
+}
+
+// schemaChange is a difference between the columns of two schemas.
+type schemaChange struct {
+	path        string
+	description string
+	breaking    bool
+}
+
+func (c schemaChange) String() string {
+	return fmt.Sprintf("%v: %v", c.path, c.description)
+}
+
+// compareSchemas returns the changes of the current schema, in the order of
+// its columns followed by the removed ones.
+func compareSchemas(previous, current *parquetschema.SchemaDefinition) []schemaChange {
+	return compareColumns("", previous.RootColumn.Children, current.RootColumn.Children)
+}
+
+func compareColumns(prefix string, previous, current []*parquetschema.ColumnDefinition) []schemaChange {
+	previousByName := make(map[string]*parquetschema.ColumnDefinition, len(previous))
+	for _, c := range previous {
+		previousByName[c.SchemaElement.GetName()] = c
+	}
+
+	var changes []schemaChange
+	seen := make(map[string]bool, len(current))
+	for _, c := range current {
+		name := c.SchemaElement.GetName()
+		path := prefix + name
+		seen[name] = true
+
+		p, ok := previousByName[name]
+		if !ok {
+			// Files written before the column was added have no values for
+			// it, so only optional and repeated columns can be added.
+			required := c.SchemaElement.GetRepetitionType() == parquet.FieldRepetitionType_REQUIRED
+			changes = append(changes, schemaChange{path: path, description: fmt.Sprintf("added %v %v", repetition(c), columnType(c)), breaking: required})
+			continue
+		}
+
+		if previousType, currentType := columnType(p), columnType(c); previousType != currentType {
+			changes = append(changes, schemaChange{path: path, description: fmt.Sprintf("type changed from %v to %v", previousType, currentType), breaking: true})
+			continue
+		}
+		if previousRep, currentRep := p.SchemaElement.GetRepetitionType(), c.SchemaElement.GetRepetitionType(); previousRep != currentRep {
+			relaxed := previousRep == parquet.FieldRepetitionType_REQUIRED && currentRep == parquet.FieldRepetitionType_OPTIONAL
+			changes = append(changes, schemaChange{path: path, description: fmt.Sprintf("changed from %v to %v", repetition(p), repetition(c)), breaking: !relaxed})
+		}
+		if len(c.Children) > 0 {
+			changes = append(changes, compareColumns(path+".", p.Children, c.Children)...)
+		}
+	}
+
+	for _, p := range previous {
+		if name := p.SchemaElement.GetName(); !seen[name] {
+			changes = append(changes, schemaChange{path: prefix + name, description: fmt.Sprintf("removed %v %v", repetition(p), columnType(p)), breaking: true})
+		}
+	}
+	return changes
+}
+
+func repetition(c *parquetschema.ColumnDefinition) string {
+	return strings.ToLower(c.SchemaElement.GetRepetitionType().String())
+}
+
+// columnType describes the physical and logical type of a column, logical
+// types are described the same way whether they are set as a logical or as a
+// converted type, as files may carry either.
+func columnType(c *parquetschema.ColumnDefinition) string {
+	e := c.SchemaElement
+	var t string
+	if len(c.Children) > 0 {
+		t = "group"
+	} else {
+		t = strings.ToLower(e.GetType().String())
+		if e.GetType() == parquet.Type_FIXED_LEN_BYTE_ARRAY {
+			t = fmt.Sprintf("%v(%v)", t, e.GetTypeLength())
+		}
+	}
+
+	lt := logicalType(e)
+	switch {
+	case lt.IsSetSTRING() || hasConvertedType(e, parquet.ConvertedType_UTF8):
+		return t + " (STRING)"
+	case lt.IsSetENUM() || hasConvertedType(e, parquet.ConvertedType_ENUM):
+		return t + " (ENUM)"
+	case lt.IsSetJSON() || hasConvertedType(e, parquet.ConvertedType_JSON):
+		return t + " (JSON)"
+	case lt.IsSetBSON() || hasConvertedType(e, parquet.ConvertedType_BSON):
+		return t + " (BSON)"
+	case lt.IsSetUUID():
+		return t + " (UUID)"
+	case lt.IsSetDATE() || hasConvertedType(e, parquet.ConvertedType_DATE):
+		return t + " (DATE)"
+	case lt.IsSetLIST() || hasConvertedType(e, parquet.ConvertedType_LIST):
+		return t + " (LIST)"
+	case lt.IsSetMAP() || hasConvertedType(e, parquet.ConvertedType_MAP, parquet.ConvertedType_MAP_KEY_VALUE):
+		return t + " (MAP)"
+	case isDecimal(e):
+		return fmt.Sprintf("%v (DECIMAL(%v, %v))", t, decimalPrecision(e), decimalScale(e))
+	case timestampUnit(e) != noTimeUnit && e.GetType() != parquet.Type_INT96:
+		// Converted timestamp types are adjusted to UTC.
+		adjusted := !lt.IsSetTIMESTAMP() || lt.TIMESTAMP.GetIsAdjustedToUTC()
+		return fmt.Sprintf("%v (TIMESTAMP(%v, %v))", t, timeUnitName(timestampUnit(e)), adjusted)
+	case lt.IsSetTIME():
+		return fmt.Sprintf("%v (TIME(%v, %v))", t, timeUnitName(timeOfDayUnit(lt.TIME.GetUnit())), lt.TIME.GetIsAdjustedToUTC())
+	case lt.IsSetINTEGER():
+		return fmt.Sprintf("%v (INT(%v, %v))", t, lt.INTEGER.GetBitWidth(), lt.INTEGER.GetIsSigned())
+	case e.IsSetConvertedType():
+		return fmt.Sprintf("%v (%v)", t, e.GetConvertedType())
+	}
+	return t
+}
+
+func timeOfDayUnit(unit *parquet.TimeUnit) timeUnit {
+	switch {
+	case unit.IsSetMILLIS():
+		return millis
+	case unit.IsSetMICROS():
+		return micros
+	}
+	return nanos
+}
+
+func timeUnitName(unit timeUnit) string {
+	switch unit {
+	case millis:
+		return "MILLIS"
+	case micros:
+		return "MICROS"
+	case nanos:
+		return "NANOS"
+	}
+	return "NONE"
+}
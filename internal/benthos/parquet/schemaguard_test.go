@@ -0,0 +1,264 @@
+package parquet
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/benthosdev/benthos/v4/public/service"
+	"github.com/fraugster/parquet-go/parquetschema"
+	"github.com/utilitywarehouse/data-products-definitions/pkg/catalog/v1"
+)
+
+func TestCompareSchemas(t *testing.T) {
+	previous, err := parquetschema.ParseSchemaDefinition(`message test {
+		required binary id (STRING);
+		optional int64 amount;
+		optional binary name (STRING);
+		required boolean active;
+		optional group address {
+			required binary city (STRING);
+		}
+		optional binary legacy (STRING);
+	}`)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	current, err := parquetschema.ParseSchemaDefinition(`message test {
+		required binary id (STRING);
+		optional double amount;
+		required binary name (STRING);
+		optional boolean active;
+		optional group address {
+			required binary city (STRING);
+			optional binary postcode (STRING);
+		}
+		optional binary email (STRING);
+		required binary country (STRING);
+	}`)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	expected := []struct {
+		path     string
+		breaking bool
+	}{
+		{"amount", true},
+		{"name", true},
+		{"active", false},
+		{"address.postcode", false},
+		{"email", false},
+		{"country", true},
+		{"legacy", true},
+	}
+	changes := compareSchemas(previous, current)
+	if len(changes) != len(expected) {
+		t.Fatalf("Expected %v changes got %v", len(expected), changes)
+	}
+	for i, c := range changes {
+		if c.path != expected[i].path || c.breaking != expected[i].breaking {
+			t.Fatalf("Expected change %v of %v to be breaking=%v got %v breaking=%v", i, expected[i].path, expected[i].breaking, c, c.breaking)
+		}
+	}
+
+	if changes := compareSchemas(current, current); len(changes) != 0 {
+		t.Fatalf("Expected no changes got %v", changes)
+	}
+}
+
+func TestParquetProcessorSchemaGuard(t *testing.T) {
+	dir := t.TempDir()
+	record := filepath.Join(dir, "schema.txt")
+	err := os.WriteFile(record, []byte(`message caps_v1_consent_and_preference {
+		required binary citizen_id (STRING);
+		optional binary consent_references (STRING);
+		required int64 version;
+	}`), 0o644)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	newProcessor := func(previous string, allowBreaking bool) (*dataProductProcessor, error) {
+		conf, err := processorConfig().ParseYAML(fmt.Sprintf(`
+dataProductID: 75d44fdc-dffd-42ea-af06-06fa4cb6fdbd
+schema_guard:
+  previous_schema: %v
+  allow_breaking_changes: %v
+`, previous, allowBreaking), nil)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		return newParquetProcessorFromConfig(catalog.New("../../../testassets/datadefinitions"), conf, service.MockResources().Logger())
+	}
+
+	_, err = newProcessor(record, false)
+	if err == nil || !strings.Contains(err.Error(), "version: removed required int64") {
+		t.Fatalf("Expected breaking change error got %v", err)
+	}
+	proc, err := newProcessor(record, true)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	// Files published with the current schema have no changes.
+	result, err := proc.ProcessBatch(context.Background(), service.MessageBatch{service.NewMessage([]byte(fmt.Sprintf(`{"citizen_id": "%s"}`, expectedCitizenID)))})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	payload, err := result[0][0].AsBytes()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	published := filepath.Join(dir, "published.parquet")
+	if err := os.WriteFile(published, payload, 0o644); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if _, err := newProcessor(published, false); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+}
+
+func TestParquetProcessorSchemaRecord(t *testing.T) {
+	// A GCS emulator serving and storing the objects of a bucket.
+	var mu sync.Mutex
+	objects := map[string][]byte{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Method == http.MethodPost {
+			// Multipart uploads of the object metadata followed by its media.
+			_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			mr := multipart.NewReader(r.Body, params["boundary"])
+			var meta struct {
+				Bucket string `json:"bucket"`
+				Name   string `json:"name"`
+			}
+			for i := 0; i < 2; i++ {
+				part, err := mr.NextPart()
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				b, _ := io.ReadAll(part)
+				if i == 0 {
+					json.Unmarshal(b, &meta)
+					continue
+				}
+				objects["/"+meta.Bucket+"/"+meta.Name] = b
+			}
+			json.NewEncoder(w).Encode(meta)
+			return
+		}
+		b, ok := objects[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(b)
+	}))
+	defer srv.Close()
+	t.Setenv("STORAGE_EMULATOR_HOST", srv.URL)
+
+	const path = "/bucket/schemas/75d44fdc-dffd-42ea-af06-06fa4cb6fdbd.parquetschema"
+	newProcessor := func() (*dataProductProcessor, error) {
+		conf, err := processorConfig().ParseYAML(`
+dataProductID: 75d44fdc-dffd-42ea-af06-06fa4cb6fdbd
+schema_guard:
+  previous_schema: gs://bucket/schemas/75d44fdc-dffd-42ea-af06-06fa4cb6fdbd.parquetschema
+  record_path: gs://bucket/schemas/75d44fdc-dffd-42ea-af06-06fa4cb6fdbd.parquetschema
+`, nil)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		return newParquetProcessorFromConfig(catalog.New("../../../testassets/datadefinitions"), conf, service.MockResources().Logger())
+	}
+	validBatch := service.MessageBatch{service.NewMessage([]byte(fmt.Sprintf(`{"citizen_id": "%s"}`, expectedCitizenID)))}
+
+	// Nothing is checked before the first publish.
+	proc, err := newProcessor()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		result, err := proc.ProcessBatch(context.Background(), validBatch)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if len(result[0]) != 1 {
+			t.Fatalf("Expected only a file got %v messages", len(result[0]))
+		}
+	}
+	mu.Lock()
+	_, ok := objects[path]
+	mu.Unlock()
+	if ok {
+		t.Fatal("Expected no schema record before the processor closes")
+	}
+
+	// The record is written once, on close.
+	if err := proc.Close(context.Background()); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	mu.Lock()
+	record, ok := objects[path]
+	mu.Unlock()
+	if !ok {
+		t.Fatal("Expected a schema record after the processor closed")
+	}
+
+	// The next run is checked against the published record.
+	proc, err = newProcessor()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	// A run with a failed batch doesn't write a record, even after successful
+	// batches.
+	mu.Lock()
+	delete(objects, path)
+	mu.Unlock()
+	if _, err := proc.ProcessBatch(context.Background(), validBatch); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if _, err := proc.ProcessBatch(context.Background(), service.MessageBatch{service.NewMessage([]byte(`not json`))}); err == nil {
+		t.Fatal("Expected an error")
+	}
+	if err := proc.Close(context.Background()); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	mu.Lock()
+	_, ok = objects[path]
+	objects[path] = record
+	mu.Unlock()
+	if ok {
+		t.Fatal("Expected no schema record after a failed batch")
+	}
+	if _, err := newProcessor(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	mu.Lock()
+	objects[path] = []byte(`message caps_v1_consent_and_preference {
+		required binary citizen_id (STRING);
+		required int64 version;
+	}`)
+	mu.Unlock()
+	if _, err := newProcessor(); err == nil || !strings.Contains(err.Error(), "version: removed required int64") {
+		t.Fatalf("Expected breaking change error got %v", err)
+	}
+}
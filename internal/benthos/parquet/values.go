@@ -0,0 +1,306 @@
+package parquet
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"time"
+
+	goparquet "github.com/fraugster/parquet-go"
+	"github.com/fraugster/parquet-go/parquet"
+	"github.com/fraugster/parquet-go/parquetschema"
+	"github.com/google/uuid"
+)
+
+// The processors of every format validate and convert rows to the types of the
+// parquet schema of the definition, the other formats decode those values back
+// to their logical types.
+
+type groupKind int
+
+const (
+	recordGroup groupKind = iota
+	listGroup
+	mapGroup
+)
+
+// kindOfGroup returns how a group column is encoded, lists and maps follow the
+// three level structure of the parquet specification.
+func kindOfGroup(c *parquetschema.ColumnDefinition) groupKind {
+	e := c.SchemaElement
+	if len(c.Children) == 1 && len(c.Children[0].Children) > 0 &&
+		c.Children[0].SchemaElement.GetRepetitionType() == parquet.FieldRepetitionType_REPEATED {
+		switch {
+		case logicalType(e).IsSetLIST() || hasConvertedType(e, parquet.ConvertedType_LIST):
+			if len(c.Children[0].Children) == 1 {
+				return listGroup
+			}
+		case logicalType(e).IsSetMAP() || hasConvertedType(e, parquet.ConvertedType_MAP, parquet.ConvertedType_MAP_KEY_VALUE):
+			if len(c.Children[0].Children) == 2 {
+				return mapGroup
+			}
+		}
+	}
+	return recordGroup
+}
+
+// repeatedValues returns the values of a repeated column.
+func repeatedValues(v interface{}) ([]interface{}, error) {
+	switch t := v.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		return t, nil
+	case []map[string]interface{}:
+		values := make([]interface{}, len(t))
+		for i, e := range t {
+			values[i] = e
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("unexpected %T value of repeated column", v)
+}
+
+// groupEntries returns the entries of the repeated group of a list or map.
+func groupEntries(c *parquetschema.ColumnDefinition, v interface{}) ([]map[string]interface{}, error) {
+	group, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected %T value of group %v", v, c.SchemaElement.GetName())
+	}
+	values, err := repeatedValues(group[c.Children[0].SchemaElement.GetName()])
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]map[string]interface{}, len(values))
+	for i, e := range values {
+		if entries[i], ok = e.(map[string]interface{}); !ok {
+			return nil, fmt.Errorf("unexpected %T value of group %v", e, c.Children[0].SchemaElement.GetName())
+		}
+	}
+	return entries, nil
+}
+
+// logicalType returns the logical type of a column, which is empty if not set.
+func logicalType(e *parquet.SchemaElement) *parquet.LogicalType {
+	if lt := e.GetLogicalType(); lt != nil {
+		return lt
+	}
+	return parquet.NewLogicalType()
+}
+
+func hasConvertedType(e *parquet.SchemaElement, types ...parquet.ConvertedType) bool {
+	if !e.IsSetConvertedType() {
+		return false
+	}
+	for _, t := range types {
+		if e.GetConvertedType() == t {
+			return true
+		}
+	}
+	return false
+}
+
+type timeUnit int
+
+const (
+	noTimeUnit timeUnit = iota
+	millis
+	micros
+	nanos
+)
+
+func timestampUnit(e *parquet.SchemaElement) timeUnit {
+	if lt := logicalType(e); lt.IsSetTIMESTAMP() {
+		switch unit := lt.TIMESTAMP.GetUnit(); {
+		case unit.IsSetMILLIS():
+			return millis
+		case unit.IsSetMICROS():
+			return micros
+		case unit.IsSetNANOS():
+			return nanos
+		}
+	}
+	switch {
+	case hasConvertedType(e, parquet.ConvertedType_TIMESTAMP_MILLIS):
+		return millis
+	case hasConvertedType(e, parquet.ConvertedType_TIMESTAMP_MICROS):
+		return micros
+	}
+	if e.GetType() == parquet.Type_INT96 {
+		return nanos
+	}
+	return noTimeUnit
+}
+
+func isString(e *parquet.SchemaElement) bool {
+	lt := logicalType(e)
+	return lt.IsSetSTRING() || lt.IsSetENUM() || lt.IsSetJSON() ||
+		hasConvertedType(e, parquet.ConvertedType_UTF8, parquet.ConvertedType_ENUM, parquet.ConvertedType_JSON)
+}
+
+func isDate(e *parquet.SchemaElement) bool {
+	return logicalType(e).IsSetDATE() || hasConvertedType(e, parquet.ConvertedType_DATE)
+}
+
+func isDecimal(e *parquet.SchemaElement) bool {
+	return logicalType(e).IsSetDECIMAL() || hasConvertedType(e, parquet.ConvertedType_DECIMAL)
+}
+
+// isInteger reports whether a column holds plain integers, rather than dates,
+// timestamps or decimals encoded as integers.
+func isInteger(e *parquet.SchemaElement) bool {
+	t := e.GetType()
+	return (t == parquet.Type_INT32 || t == parquet.Type_INT64) &&
+		!isDate(e) && !isDecimal(e) && timestampUnit(e) == noTimeUnit
+}
+
+func isUUID(e *parquet.SchemaElement) bool {
+	return logicalType(e).IsSetUUID() && e.GetType() == parquet.Type_FIXED_LEN_BYTE_ARRAY && e.GetTypeLength() == 16
+}
+
+// decimalScale returns the scale of a decimal column, from its logical type if
+// set.
+func decimalScale(e *parquet.SchemaElement) int32 {
+	if lt := logicalType(e); lt.IsSetDECIMAL() {
+		return lt.DECIMAL.GetScale()
+	}
+	return e.GetScale()
+}
+
+func decimalPrecision(e *parquet.SchemaElement) int32 {
+	if lt := logicalType(e); lt.IsSetDECIMAL() {
+		return lt.DECIMAL.GetPrecision()
+	}
+	return e.GetPrecision()
+}
+
+// logicalValue decodes a value of a primitive column to its logical type:
+// strings, uuids, timestamps, dates and decimals are returned as string,
+// time.Time and *big.Rat, other values are returned as is.
+func logicalValue(e *parquet.SchemaElement, v interface{}) (interface{}, error) {
+	switch {
+	case isString(e):
+		b, ok := v.([]byte)
+		if !ok {
+			return nil, fmt.Errorf("unexpected %T value of string column %v", v, e.GetName())
+		}
+		return string(b), nil
+	case isUUID(e):
+		b, ok := v.([]byte)
+		if !ok || len(b) != 16 {
+			return nil, fmt.Errorf("unexpected %T value of uuid column %v", v, e.GetName())
+		}
+		var id uuid.UUID
+		copy(id[:], b)
+		return id.String(), nil
+	case isDate(e):
+		d, ok := v.(int32)
+		if !ok {
+			return nil, fmt.Errorf("unexpected %T value of date column %v", v, e.GetName())
+		}
+		return time.Unix(int64(d)*24*60*60, 0).UTC(), nil
+	case isDecimal(e):
+		var unscaled *big.Int
+		switch t := v.(type) {
+		case int32:
+			unscaled = big.NewInt(int64(t))
+		case int64:
+			unscaled = big.NewInt(t)
+		case []byte:
+			unscaled = twosComplement(t)
+		default:
+			return nil, fmt.Errorf("unexpected %T value of decimal column %v", v, e.GetName())
+		}
+		scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimalScale(e))), nil)
+		return new(big.Rat).SetFrac(unscaled, scale), nil
+	}
+
+	switch unit := timestampUnit(e); {
+	case unit == noTimeUnit:
+		return v, nil
+	case e.GetType() == parquet.Type_INT96:
+		b, ok := v.([12]byte)
+		if !ok {
+			return nil, fmt.Errorf("unexpected %T value of timestamp column %v", v, e.GetName())
+		}
+		return goparquet.Int96ToTime(b).UTC(), nil
+	default:
+		ts, ok := v.(int64)
+		if !ok {
+			return nil, fmt.Errorf("unexpected %T value of timestamp column %v", v, e.GetName())
+		}
+		switch unit {
+		case millis:
+			return time.UnixMilli(ts).UTC(), nil
+		case micros:
+			return time.UnixMicro(ts).UTC(), nil
+		}
+		return time.Unix(0, ts).UTC(), nil
+	}
+}
+
+// twosComplement decodes a big endian two's complement integer.
+func twosComplement(b []byte) *big.Int {
+	i := new(big.Int).SetBytes(b)
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		i.Sub(i, new(big.Int).Lsh(big.NewInt(1), uint(len(b)*8)))
+	}
+	return i
+}
+
+// decimalValue converts a number to the unscaled integer of a decimal column in
+// its physical type, exactly, as a float64 can't hold the digits of every
+// decimal.
+func decimalValue(e *parquet.SchemaElement, n json.Number) (interface{}, error) {
+	r, ok := new(big.Rat).SetString(string(n))
+	if !ok {
+		return nil, fmt.Errorf("invalid number %q", n)
+	}
+	scale, precision := decimalScale(e), decimalPrecision(e)
+	r.Mul(r, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)))
+	if !r.IsInt() {
+		return nil, fmt.Errorf("%v has more than %v decimal places", n, scale)
+	}
+	unscaled := r.Num()
+	if precision > 0 && len(new(big.Int).Abs(unscaled).String()) > int(precision) {
+		return nil, fmt.Errorf("%v doesn't fit DECIMAL(%v, %v)", n, precision, scale)
+	}
+
+	switch e.GetType() {
+	case parquet.Type_INT32:
+		if b, ok := twosComplementBytes(unscaled, 4); ok {
+			return int32(twosComplement(b).Int64()), nil
+		}
+	case parquet.Type_INT64:
+		if unscaled.IsInt64() {
+			return unscaled.Int64(), nil
+		}
+	case parquet.Type_FIXED_LEN_BYTE_ARRAY:
+		if b, ok := twosComplementBytes(unscaled, int(e.GetTypeLength())); ok {
+			return b, nil
+		}
+	case parquet.Type_BYTE_ARRAY:
+		b, _ := twosComplementBytes(unscaled, unscaled.BitLen()/8+1)
+		return b, nil
+	default:
+		return nil, fmt.Errorf("unexpected %v decimal column %v", e.GetType(), e.GetName())
+	}
+	return nil, fmt.Errorf("%v doesn't fit the %v column %v", n, e.GetType(), e.GetName())
+}
+
+// twosComplementBytes encodes an integer as a big endian two's complement of n
+// bytes, ok is false if it doesn't fit.
+func twosComplementBytes(i *big.Int, n int) (b []byte, ok bool) {
+	magnitude := i
+	if i.Sign() < 0 {
+		magnitude = new(big.Int).Not(i)
+	}
+	if magnitude.BitLen() > n*8-1 {
+		return nil, false
+	}
+	u := i
+	if i.Sign() < 0 {
+		u = new(big.Int).Add(i, new(big.Int).Lsh(big.NewInt(1), uint(n*8)))
+	}
+	return u.FillBytes(make([]byte, n)), true
+}
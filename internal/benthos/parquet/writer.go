@@ -0,0 +1,231 @@
+package parquet
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+
+	goparquet "github.com/fraugster/parquet-go"
+	"github.com/fraugster/parquet-go/parquet"
+	"github.com/fraugster/parquet-go/parquetschema"
+)
+
+// writerOptions are the settings parquet files are written with.
+type writerOptions struct {
+	codec           parquet.CompressionCodec
+	rowGroupSize    int64
+	pageSize        int64
+	dataPageVersion int
+	noDictionary    map[string]bool
+}
+
+func (o writerOptions) newFileWriter(w io.Writer, schemaDef *parquetschema.SchemaDefinition, extra ...goparquet.FileWriterOption) (*goparquet.FileWriter, error) {
+	opts := []goparquet.FileWriterOption{
+		goparquet.WithCompressionCodec(o.codec),
+		goparquet.WithCreator("write-lowlevel"),
+		goparquet.WithMaxRowGroupSize(o.rowGroupSize),
+		goparquet.WithMaxPageSize(o.pageSize),
+	}
+	if o.dataPageVersion == 2 {
+		opts = append(opts, goparquet.WithDataPageV2())
+	}
+	opts = append(opts, extra...)
+	if len(o.noDictionary) == 0 {
+		return goparquet.NewFileWriter(w, append(opts, goparquet.WithSchemaDefinition(schemaDef))...), nil
+	}
+
+	// Columns created from a schema definition always allow dictionary
+	// encoding, so the schema is built column by column instead.
+	defined := goparquet.NewFileWriter(ioutil.Discard, append(opts, goparquet.WithSchemaDefinition(schemaDef))...)
+	for name := range o.noDictionary {
+		if defined.GetColumnByPath(goparquet.ColumnPath{name}) == nil {
+			return nil, fmt.Errorf("dictionary disabled for unknown column %v", name)
+		}
+	}
+
+	fw := goparquet.NewFileWriter(w, opts...)
+	for _, c := range schemaDef.RootColumn.Children {
+		name := c.SchemaElement.GetName()
+		col := defined.GetColumnByPath(goparquet.ColumnPath{name})
+		if o.noDictionary[name] {
+			var err error
+			if col, err = noDictionaryColumn(col); err != nil {
+				return nil, err
+			}
+		}
+		if err := fw.AddColumnByPath(goparquet.ColumnPath{name}, col); err != nil {
+			return nil, err
+		}
+	}
+	return fw, nil
+}
+
+// noDictionaryColumn recreates a column without dictionary encoding, note that
+// it is written with the default page size.
+func noDictionaryColumn(col *goparquet.Column) (*goparquet.Column, error) {
+	if !col.DataColumn() {
+		return nil, fmt.Errorf("dictionary can only be disabled for columns of primitive types, %v is a group", col.Name())
+	}
+
+	elem := col.Element()
+	params := &goparquet.ColumnParameters{
+		LogicalType:   elem.LogicalType,
+		ConvertedType: elem.ConvertedType,
+		TypeLength:    elem.TypeLength,
+		FieldID:       elem.FieldID,
+		Scale:         elem.Scale,
+		Precision:     elem.Precision,
+	}
+
+	var store *goparquet.ColumnStore
+	var err error
+	switch *col.Type() {
+	case parquet.Type_BYTE_ARRAY:
+		store, err = goparquet.NewByteArrayStore(parquet.Encoding_PLAIN, false, params)
+	case parquet.Type_FLOAT:
+		store, err = goparquet.NewFloatStore(parquet.Encoding_PLAIN, false, params)
+	case parquet.Type_DOUBLE:
+		store, err = goparquet.NewDoubleStore(parquet.Encoding_PLAIN, false, params)
+	case parquet.Type_BOOLEAN:
+		store, err = goparquet.NewBooleanStore(parquet.Encoding_PLAIN, params)
+	case parquet.Type_INT32:
+		store, err = goparquet.NewInt32Store(parquet.Encoding_PLAIN, false, params)
+	case parquet.Type_INT64:
+		store, err = goparquet.NewInt64Store(parquet.Encoding_PLAIN, false, params)
+	case parquet.Type_INT96:
+		store, err = goparquet.NewInt96Store(parquet.Encoding_PLAIN, false, params)
+	case parquet.Type_FIXED_LEN_BYTE_ARRAY:
+		store, err = goparquet.NewFixedByteArrayStore(parquet.Encoding_PLAIN, false, params)
+	default:
+		return nil, fmt.Errorf("unsupported type %v of column %v", col.Type(), col.Name())
+	}
+	if err != nil {
+		return nil, err
+	}
+	return goparquet.NewDataColumn(store, *col.RepetitionType()), nil
+}
+
+// rollingWriter writes rows to parquet files, flushing row groups as they fill
+// up and starting a new file once the current one reaches its size limits.
+// Every file is passed to emit as soon as it is closed, so that the writer only
+// holds the open file and a single row group in memory. Every file gets the key
+// value metadata along with its row count.
+type rollingWriter struct {
+	newFile  func(io.Writer, ...goparquet.FileWriterOption) (*goparquet.FileWriter, error)
+	maxRows  int64
+	maxBytes int64
+	metadata map[string]string
+	emit     func([]byte) error
+	// stats, if set, are collected as rows are written and added to the
+	// footer of every closed file.
+	stats *statsOptions
+
+	buf       *bytes.Buffer
+	fw        *goparquet.FileWriter
+	collector *statsCollector
+	kv        map[string]string
+	rows      int64
+}
+
+func newRollingWriter(maxRows, maxBytes int64, metadata map[string]string, newFile func(io.Writer, ...goparquet.FileWriterOption) (*goparquet.FileWriter, error), emit func([]byte) error) *rollingWriter {
+	return &rollingWriter{
+		newFile:  newFile,
+		maxRows:  maxRows,
+		maxBytes: maxBytes,
+		metadata: metadata,
+		emit:     emit,
+	}
+}
+
+// add writes a row to the current file, starting a new one if needed.
+func (w *rollingWriter) add(row map[string]interface{}) error {
+	if w.fw == nil {
+		// The file writer keeps the map, so the row count can be set once
+		// the file is closed.
+		kv := make(map[string]string, len(w.metadata)+1)
+		for k, v := range w.metadata {
+			kv[k] = v
+		}
+		buf := &bytes.Buffer{}
+		fw, err := w.newFile(buf, goparquet.WithMetaData(kv))
+		if err != nil {
+			return err
+		}
+		var collector *statsCollector
+		if w.stats != nil {
+			if collector, err = w.stats.newCollector(fw.Columns()); err != nil {
+				return err
+			}
+		}
+		w.buf = buf
+		w.fw = fw
+		w.collector = collector
+		w.kv = kv
+		w.rows = 0
+	}
+
+	size := w.fw.CurrentFileSize()
+	if err := w.fw.AddData(row); err != nil {
+		return err
+	}
+	w.rows++
+	if w.collector != nil {
+		if err := w.collector.add(row); err != nil {
+			return err
+		}
+		// The file only grows when the row group including the row is
+		// flushed.
+		if w.fw.CurrentFileSize() != size {
+			w.collector.flush()
+		}
+	}
+
+	if w.maxRows > 0 && w.rows >= w.maxRows {
+		return w.roll()
+	}
+	// The size of the unflushed row group is its uncompressed size, so files
+	// tend to end up smaller than the limit.
+	if w.maxBytes > 0 && w.fw.CurrentFileSize()+w.fw.CurrentRowGroupSize() >= w.maxBytes {
+		return w.roll()
+	}
+	return nil
+}
+
+// buffered returns the size of the open file, including the uncompressed size
+// of its unflushed row group.
+func (w *rollingWriter) buffered() int64 {
+	if w.fw == nil {
+		return 0
+	}
+	return int64(w.buf.Len()) + w.fw.CurrentRowGroupSize()
+}
+
+// roll closes the current file and emits it.
+func (w *rollingWriter) roll() error {
+	if w.fw == nil {
+		return nil
+	}
+	w.kv[metaRowCount] = strconv.FormatInt(w.rows, 10)
+	if err := w.fw.Close(); err != nil {
+		return err
+	}
+	file := w.buf.Bytes()
+	if w.collector != nil {
+		var err error
+		if file, err = w.collector.finishFile(file); err != nil {
+			return err
+		}
+	}
+	w.fw = nil
+	w.buf = nil
+	w.collector = nil
+	w.kv = nil
+	return w.emit(file)
+}
+
+// close closes and emits the current file.
+func (w *rollingWriter) close() error {
+	return w.roll()
+}
@@ -0,0 +1,243 @@
+package parquet
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	goparquet "github.com/fraugster/parquet-go"
+	"github.com/fraugster/parquet-go/parquet"
+	"github.com/fraugster/parquet-go/parquetschema"
+)
+
+func testSchema(t *testing.T) *parquetschema.SchemaDefinition {
+	schemaDef, err := parquetschema.ParseSchemaDefinition(`message test {
+		required int64 id;
+		required binary name (STRING);
+	}`)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	return schemaDef
+}
+
+func testWriterOptions() writerOptions {
+	return writerOptions{
+		codec:           parquet.CompressionCodec_SNAPPY,
+		rowGroupSize:    defaultRowGroupSize,
+		pageSize:        defaultPageSize,
+		dataPageVersion: 1,
+	}
+}
+
+func testFileWriter(t *testing.T, opts writerOptions) func(io.Writer, ...goparquet.FileWriterOption) (*goparquet.FileWriter, error) {
+	schemaDef := testSchema(t)
+	return func(w io.Writer, extra ...goparquet.FileWriterOption) (*goparquet.FileWriter, error) {
+		return opts.newFileWriter(w, schemaDef, extra...)
+	}
+}
+
+func fileRowCounts(t *testing.T, files [][]byte) []int64 {
+	counts := make([]int64, 0, len(files))
+	for _, f := range files {
+		fr, err := goparquet.NewFileReader(bytes.NewReader(f))
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		counts = append(counts, fr.NumRows())
+	}
+	return counts
+}
+
+func TestRollingWriterMaxRows(t *testing.T) {
+	var files [][]byte
+	w := newRollingWriter(3, 0, nil, testFileWriter(t, testWriterOptions()), func(f []byte) error { files = append(files, f); return nil })
+	for i := 0; i < 7; i++ {
+		if err := w.add(map[string]interface{}{"id": int64(i), "name": []byte("foo")}); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	}
+
+	if err := w.close(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	counts := fileRowCounts(t, files)
+	if len(counts) != 3 || counts[0] != 3 || counts[1] != 3 || counts[2] != 1 {
+		t.Fatalf("Expected files of 3, 3 and 1 rows got %v", counts)
+	}
+}
+
+func TestRollingWriterMaxBytes(t *testing.T) {
+	opts := testWriterOptions()
+	opts.rowGroupSize = 1024
+	var files [][]byte
+	w := newRollingWriter(0, 4096, nil, testFileWriter(t, opts), func(f []byte) error { files = append(files, f); return nil })
+	for i := 0; i < 1000; i++ {
+		if err := w.add(map[string]interface{}{"id": int64(i), "name": bytes.Repeat([]byte{'a' + byte(i%26)}, 32)}); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	}
+
+	if err := w.close(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(files) < 2 {
+		t.Fatalf("Expected several files got %v", len(files))
+	}
+
+	var total int64
+	for i, c := range fileRowCounts(t, files) {
+		total += c
+		fr, err := goparquet.NewFileReader(bytes.NewReader(files[i]))
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if i < len(files)-1 && fr.RowGroupCount() < 2 {
+			t.Fatalf("Expected file %v to be flushed in several row groups", i)
+		}
+	}
+	if total != 1000 {
+		t.Fatalf("Expected 1000 rows got %v", total)
+	}
+}
+
+func TestRollingWriterBufferedSize(t *testing.T) {
+	opts := testWriterOptions()
+	opts.rowGroupSize = 1024
+	var files [][]byte
+	w := newRollingWriter(0, 4096, nil, testFileWriter(t, opts), func(f []byte) error { files = append(files, f); return nil })
+
+	// Finished files are emitted while rows are written, only the open file
+	// and its row group are buffered regardless of the number of rows.
+	var peak int64
+	for i := 0; i < 20000; i++ {
+		if err := w.add(map[string]interface{}{"id": int64(i), "name": bytes.Repeat([]byte{'a' + byte(i%26)}, 32)}); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if b := w.buffered(); b > peak {
+			peak = b
+		}
+	}
+	if peak > 4096+opts.rowGroupSize {
+		t.Fatalf("Expected at most %v bytes to be buffered got %v", 4096+opts.rowGroupSize, peak)
+	}
+	if len(files) < 10 {
+		t.Fatalf("Expected files to be emitted before the writer is closed, got %v", len(files))
+	}
+
+	if err := w.close(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if w.buffered() != 0 {
+		t.Fatalf("Expected nothing to be buffered once closed got %v", w.buffered())
+	}
+	var total int64
+	for _, c := range fileRowCounts(t, files) {
+		total += c
+	}
+	if total != 20000 {
+		t.Fatalf("Expected 20000 rows got %v", total)
+	}
+}
+
+func TestRollingWriterEmpty(t *testing.T) {
+	var files [][]byte
+	w := newRollingWriter(3, 0, nil, testFileWriter(t, testWriterOptions()), func(f []byte) error { files = append(files, f); return nil })
+	if err := w.close(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(files) != 0 {
+		t.Fatalf("Expected no files got %v", len(files))
+	}
+}
+
+func TestWriterOptions(t *testing.T) {
+	for _, compression := range []string{"none", "snappy", "gzip", "zstd", "lz4"} {
+		codec := compressionCodecs[compression]
+		if err := registerCompression(codec, noCompressionLevel); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+
+		opts := testWriterOptions()
+		opts.codec = codec
+		opts.pageSize = 512
+		opts.dataPageVersion = 2
+		opts.noDictionary = map[string]bool{"id": true}
+
+		var files [][]byte
+		w := newRollingWriter(0, 0, nil, testFileWriter(t, opts), func(f []byte) error { files = append(files, f); return nil })
+		for i := 0; i < 100; i++ {
+			if err := w.add(map[string]interface{}{"id": int64(i), "name": []byte("foo")}); err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+		}
+		if err := w.close(); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+
+		fr, err := goparquet.NewFileReader(bytes.NewReader(files[0]))
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		for i := 0; i < 100; i++ {
+			row, err := fr.NextRow()
+			if err != nil {
+				t.Fatalf("%v: unexpected error %v", compression, err)
+			}
+			if row["id"] != int64(i) || string(row["name"].([]byte)) != "foo" {
+				t.Fatalf("%v: unexpected row %v", compression, row)
+			}
+		}
+
+		chunks := fr.CurrentRowGroup().Columns
+		if chunks[0].MetaData.Codec != codec {
+			t.Fatalf("%v: expected codec %v got %v", compression, codec, chunks[0].MetaData.Codec)
+		}
+		if chunks[0].MetaData.DictionaryPageOffset != nil {
+			t.Fatalf("%v: expected id to be written without dictionary", compression)
+		}
+		if chunks[1].MetaData.DictionaryPageOffset == nil {
+			t.Fatalf("%v: expected name to be written with dictionary", compression)
+		}
+	}
+}
+
+func TestWriterOptionsUnknownColumn(t *testing.T) {
+	opts := testWriterOptions()
+	opts.noDictionary = map[string]bool{"missing": true}
+	if _, err := opts.newFileWriter(&bytes.Buffer{}, testSchema(t)); err == nil {
+		t.Fatal("Expected error")
+	}
+}
+
+func TestRegisterCompressionLevels(t *testing.T) {
+	// Levels are registered for the whole process, forget the ones used by
+	// other tests.
+	compressionLevelsMu.Lock()
+	delete(compressionLevels, parquet.CompressionCodec_LZ4_RAW)
+	compressionLevelsMu.Unlock()
+	defer func() {
+		compressionLevelsMu.Lock()
+		delete(compressionLevels, parquet.CompressionCodec_LZ4_RAW)
+		compressionLevelsMu.Unlock()
+		if err := registerCompression(parquet.CompressionCodec_LZ4_RAW, noCompressionLevel); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	}()
+
+	if err := registerCompression(parquet.CompressionCodec_SNAPPY, 3); err == nil {
+		t.Fatal("Expected error")
+	}
+	if err := registerCompression(parquet.CompressionCodec_LZ4_RAW, 20); err == nil {
+		t.Fatal("Expected error")
+	}
+	if err := registerCompression(parquet.CompressionCodec_LZ4_RAW, 9); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if err := registerCompression(parquet.CompressionCodec_LZ4_RAW, 9); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if err := registerCompression(parquet.CompressionCodec_LZ4_RAW, 1); err == nil {
+		t.Fatal("Expected error")
+	}
+}
@@ -0,0 +1,46 @@
+package pgcdc
+
+import (
+	"strconv"
+
+	"github.com/jackc/pgtype"
+)
+
+// decodeText decodes a text encoded column value into the same Go types the
+// uw_sql_raw input produces, types without a natural Go representation (e.g.
+// numeric, uuid and jsonb) are kept as their text form.
+func decodeText(ci *pgtype.ConnInfo, oid uint32, data []byte) (interface{}, error) {
+	switch oid {
+	case pgtype.BoolOID:
+		return strconv.ParseBool(string(data))
+	case pgtype.Int2OID, pgtype.Int4OID, pgtype.Int8OID:
+		return strconv.ParseInt(string(data), 10, 64)
+	case pgtype.Float4OID, pgtype.Float8OID:
+		return strconv.ParseFloat(string(data), 64)
+	case pgtype.DateOID:
+		var v pgtype.Date
+		if err := v.DecodeText(ci, data); err != nil {
+			return nil, err
+		}
+		return v.Time, nil
+	case pgtype.TimestampOID:
+		var v pgtype.Timestamp
+		if err := v.DecodeText(ci, data); err != nil {
+			return nil, err
+		}
+		return v.Time, nil
+	case pgtype.TimestamptzOID:
+		var v pgtype.Timestamptz
+		if err := v.DecodeText(ci, data); err != nil {
+			return nil, err
+		}
+		return v.Time, nil
+	case pgtype.ByteaOID:
+		var v pgtype.Bytea
+		if err := v.DecodeText(ci, data); err != nil {
+			return nil, err
+		}
+		return v.Bytes, nil
+	}
+	return string(data), nil
+}
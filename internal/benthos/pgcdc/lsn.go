@@ -0,0 +1,92 @@
+package pgcdc
+
+import (
+	"sync"
+
+	"github.com/jackc/pglogrepl"
+)
+
+// pendingTx is a transaction received from the slot whose changes have not
+// all been delivered yet.
+type pendingTx struct {
+	lsn       pglogrepl.LSN
+	pending   int
+	committed bool
+}
+
+// lsnTracker keeps track of the position of the slot that can be safely
+// acknowledged to the server, that is the end of the last transaction for
+// which it and every transaction before it have been delivered.
+type lsnTracker struct {
+	mu    sync.Mutex
+	txs   []*pendingTx
+	flush pglogrepl.LSN
+}
+
+func (t *lsnTracker) begin(lsn pglogrepl.LSN) *pendingTx {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tx := &pendingTx{lsn: lsn}
+	t.txs = append(t.txs, tx)
+	return tx
+}
+
+func (t *lsnTracker) add(tx *pendingTx) {
+	t.mu.Lock()
+	tx.pending++
+	t.mu.Unlock()
+}
+
+func (t *lsnTracker) ack(tx *pendingTx) {
+	t.mu.Lock()
+	tx.pending--
+	t.advance()
+	t.mu.Unlock()
+}
+
+// commit marks a transaction as fully received, endLSN is the position
+// following it.
+func (t *lsnTracker) commit(tx *pendingTx, endLSN pglogrepl.LSN) {
+	if tx == nil {
+		return
+	}
+	t.mu.Lock()
+	tx.lsn = endLSN
+	tx.committed = true
+	t.advance()
+	t.mu.Unlock()
+}
+
+// idle moves the position forward to the end of the server WAL when there are
+// no transactions in flight, so that the slot doesn't retain WAL unrelated to
+// the table.
+func (t *lsnTracker) idle(lsn pglogrepl.LSN) {
+	t.mu.Lock()
+	if len(t.txs) == 0 && lsn > t.flush {
+		t.flush = lsn
+	}
+	t.mu.Unlock()
+}
+
+// delivered reports whether a transaction has been received and every change
+// of it delivered.
+func (t *lsnTracker) delivered(tx *pendingTx) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return tx.committed && tx.pending <= 0
+}
+
+func (t *lsnTracker) flushed() pglogrepl.LSN {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.flush
+}
+
+func (t *lsnTracker) advance() {
+	for len(t.txs) > 0 && t.txs[0].committed && t.txs[0].pending <= 0 {
+		if t.txs[0].lsn > t.flush {
+			t.flush = t.txs[0].lsn
+		}
+		t.txs = t.txs[1:]
+	}
+}
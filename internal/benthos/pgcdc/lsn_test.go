@@ -0,0 +1,46 @@
+package pgcdc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLSNTrackerAcknowledgesInOrder(t *testing.T) {
+	tracker := &lsnTracker{}
+
+	first := tracker.begin(10)
+	tracker.add(first)
+	tracker.commit(first, 15)
+
+	second := tracker.begin(20)
+	tracker.add(second)
+	tracker.add(second)
+	tracker.commit(second, 25)
+
+	// The second transaction can't be acknowledged before the first one.
+	tracker.ack(second)
+	tracker.ack(second)
+	assert.Equal(t, uint64(0), uint64(tracker.flushed()))
+
+	tracker.ack(first)
+	assert.Equal(t, uint64(25), uint64(tracker.flushed()))
+}
+
+func TestLSNTrackerWaitsForCommit(t *testing.T) {
+	tracker := &lsnTracker{}
+
+	tx := tracker.begin(10)
+	tracker.add(tx)
+	tracker.ack(tx)
+	assert.Equal(t, uint64(0), uint64(tracker.flushed()))
+
+	tracker.idle(100)
+	assert.Equal(t, uint64(0), uint64(tracker.flushed()))
+
+	tracker.commit(tx, 15)
+	assert.Equal(t, uint64(15), uint64(tracker.flushed()))
+
+	tracker.idle(100)
+	assert.Equal(t, uint64(100), uint64(tracker.flushed()))
+}
@@ -0,0 +1,123 @@
+package sql
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/benthosdev/benthos/v4/public/service"
+)
+
+func chunkingField() *service.ConfigField {
+	return service.NewObjectField("chunking",
+		service.NewStringField("key_column").
+			Description("A unique, orderable column of the query result used to page through it.").
+			Example("id"),
+		service.NewIntField("page_size").
+			Description("The maximum number of rows fetched by each page query.").
+			Default(10000),
+	).
+		Description("Reads the query result in pages ordered by `key_column` instead of holding a single cursor open for the whole export. Each page is a short query of the form `WHERE key_column > last_key ORDER BY key_column LIMIT page_size`, and a failed page is retried from the last key read.").
+		Optional().
+		Advanced()
+}
+
+// keysetPager reads a query in pages ordered by a key column, a page size of 0
+// reads the rest of the query result in a single page.
+type keysetPager struct {
+	keyColumn string
+	pageSize  int
+
+	lastKey  interface{}
+	pageRows int
+	done     bool
+}
+
+func keysetPagerFromParsed(conf *service.ParsedConfig) (*keysetPager, error) {
+	keyColumn, err := conf.FieldString("key_column")
+	if err != nil {
+		return nil, err
+	}
+
+	pageSize, err := conf.FieldInt("page_size")
+	if err != nil {
+		return nil, err
+	}
+	if pageSize <= 0 {
+		return nil, fmt.Errorf("page_size must be greater than 0, got %v", pageSize)
+	}
+
+	return &keysetPager{
+		keyColumn: keyColumn,
+		pageSize:  pageSize,
+	}, nil
+}
+
+// pageQuery wraps the query so that it returns the page following the last key
+// read, args are the arguments of the wrapped query.
+func (p *keysetPager) pageQuery(driver, query string, args []interface{}) (string, []interface{}, error) {
+	if driver == "mssql" {
+		return "", nil, fmt.Errorf("chunking is not supported by the %v driver", driver)
+	}
+
+	key := "uw_page." + quoteIdentifier(driver, p.keyColumn)
+
+	var where string
+	if p.lastKey != nil {
+		args = append(args, p.lastKey)
+		where = fmt.Sprintf(" WHERE %s > %s", key, placeholder(driver, len(args)))
+	}
+	p.pageRows = 0
+
+	var limit string
+	if p.pageSize > 0 {
+		limit = fmt.Sprintf(" LIMIT %d", p.pageSize)
+	}
+
+	return fmt.Sprintf("SELECT * FROM (%s) AS uw_page%s ORDER BY %s%s", trimQuery(query), where, key, limit), args, nil
+}
+
+// observe records the key of a row read from the current page.
+func (p *keysetPager) observe(row map[string]interface{}) error {
+	v, ok := row[p.keyColumn]
+	if !ok {
+		return fmt.Errorf("chunking key column %v not present in query result", p.keyColumn)
+	}
+	if v == nil {
+		return fmt.Errorf("chunking key column %v must not be null", p.keyColumn)
+	}
+	if b, ok := v.([]byte); ok {
+		// Passed back as text so that e.g. uuid and numeric keys compare
+		// correctly.
+		v = string(b)
+	}
+	p.lastKey = v
+	p.pageRows++
+	return nil
+}
+
+// endPage marks the current page as fully read, a page shorter than the page
+// size means the query has been exhausted.
+func (p *keysetPager) endPage() {
+	if p.pageSize == 0 || p.pageRows < p.pageSize {
+		p.done = true
+	}
+}
+
+// placeholder returns the nth (starting from 1) query placeholder in the style
+// of the driver.
+func placeholder(driver string, n int) string {
+	switch driver {
+	case "mysql", "mssql":
+		return "?"
+	}
+	return fmt.Sprintf("$%d", n)
+}
+
+// quoteIdentifier quotes a column name in the style of the driver, so that
+// mixed case and reserved names can be used.
+func quoteIdentifier(driver, name string) string {
+	if driver == "mysql" {
+		return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+	}
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -0,0 +1,175 @@
+package sql
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// columnType describes a column of a query result, it is exposed as the
+// message metadata field sql_column_types.
+type columnType struct {
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	Precision *int64 `json:"precision,omitempty"`
+	Scale     *int64 `json:"scale,omitempty"`
+	Nullable  *bool  `json:"nullable,omitempty"`
+}
+
+func sqlColumnTypes(rows *sql.Rows) ([]columnType, error) {
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make([]columnType, len(types))
+	for i, t := range types {
+		columns[i] = columnType{
+			Name: t.Name(),
+			Type: strings.ToUpper(t.DatabaseTypeName()),
+		}
+		if precision, scale, ok := t.DecimalSize(); ok {
+			columns[i].Precision = &precision
+			columns[i].Scale = &scale
+		}
+		if nullable, ok := t.Nullable(); ok {
+			columns[i].Nullable = &nullable
+		}
+	}
+	return columns, nil
+}
+
+func columnTypesMeta(columns []columnType) string {
+	b, err := json.Marshal(columns)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+func sqlRowToMap(rows *sql.Rows, columns []columnType) (map[string]interface{}, error) {
+	values := make([]interface{}, len(columns))
+	valuesWrapped := make([]interface{}, len(columns))
+	for i := range values {
+		valuesWrapped[i] = &values[i]
+	}
+	if err := rows.Scan(valuesWrapped...); err != nil {
+		return nil, err
+	}
+	jObj := make(map[string]interface{}, len(columns))
+	for i, v := range values {
+		dv, err := decodeColumn(columns[i].Type, v)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode column %v of type %v err=%v", columns[i].Name, columns[i].Type, err)
+		}
+		jObj[columns[i].Name] = dv
+	}
+	return jObj, nil
+}
+
+// decodeColumn normalises a value returned by the driver according to the
+// database type of its column:
+//
+// - integers are int64 and floating point numbers float64
+// - numeric, uuid, json and text types are strings
+// - date and timestamp types are time.Time, in UTC when they have a time zone
+// - binary types are []byte
+func decodeColumn(dbType string, v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	switch dbType {
+	case "INT2", "INT4", "INT8", "SMALLINT", "INT", "INTEGER", "BIGINT", "TINYINT", "MEDIUMINT",
+		"INT16", "INT32", "INT64", "UINT8", "UINT16", "UINT32":
+		return toInt64(v)
+	case "FLOAT4", "FLOAT8", "REAL", "DOUBLE", "FLOAT", "FLOAT32", "FLOAT64":
+		return toFloat64(v)
+	case "BOOL", "BOOLEAN":
+		return toBool(v)
+	case "NUMERIC", "DECIMAL", "UUID", "JSON", "JSONB", "TEXT", "VARCHAR", "BPCHAR", "CHAR", "NAME", "STRING":
+		return toString(v), nil
+	case "TIMESTAMPTZ":
+		if t, ok := v.(time.Time); ok {
+			return t.UTC(), nil
+		}
+	case "BYTEA", "BLOB", "BINARY", "VARBINARY":
+		if b, ok := v.([]byte); ok {
+			return b, nil
+		}
+	}
+
+	switch t := v.(type) {
+	case []byte:
+		return string(t), nil
+	default:
+		return t, nil
+	}
+}
+
+func toInt64(v interface{}) (interface{}, error) {
+	switch t := v.(type) {
+	case int64:
+		return t, nil
+	case int:
+		return int64(t), nil
+	case int8:
+		return int64(t), nil
+	case int16:
+		return int64(t), nil
+	case int32:
+		return int64(t), nil
+	case uint8:
+		return int64(t), nil
+	case uint16:
+		return int64(t), nil
+	case uint32:
+		return int64(t), nil
+	case []byte:
+		return strconv.ParseInt(string(t), 10, 64)
+	case string:
+		return strconv.ParseInt(t, 10, 64)
+	}
+	return nil, fmt.Errorf("unexpected integer value of type %T", v)
+}
+
+func toFloat64(v interface{}) (interface{}, error) {
+	switch t := v.(type) {
+	case float64:
+		return t, nil
+	case float32:
+		return float64(t), nil
+	case []byte:
+		return strconv.ParseFloat(string(t), 64)
+	case string:
+		return strconv.ParseFloat(t, 64)
+	}
+	return nil, fmt.Errorf("unexpected floating point value of type %T", v)
+}
+
+func toBool(v interface{}) (interface{}, error) {
+	switch t := v.(type) {
+	case bool:
+		return t, nil
+	case int64:
+		return t != 0, nil
+	case []byte:
+		return strconv.ParseBool(string(t))
+	case string:
+		return strconv.ParseBool(t)
+	}
+	return nil, fmt.Errorf("unexpected boolean value of type %T", v)
+}
+
+func toString(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case []byte:
+		return string(t)
+	}
+	return fmt.Sprintf("%v", v)
+}
@@ -0,0 +1,52 @@
+package sql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeColumn(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	require.NoError(t, err)
+
+	tests := []struct {
+		dbType   string
+		value    interface{}
+		expected interface{}
+	}{
+		{"INT8", int64(42), int64(42)},
+		{"INT4", []byte("42"), int64(42)},
+		{"FLOAT8", 1.5, 1.5},
+		{"FLOAT4", []byte("1.5"), 1.5},
+		{"BOOL", true, true},
+		{"NUMERIC", []byte("12.34"), "12.34"},
+		{"UUID", []byte("75d44fdc-dffd-42ea-af06-06fa4cb6fdbd"), "75d44fdc-dffd-42ea-af06-06fa4cb6fdbd"},
+		{"JSONB", []byte(`{"a": 1}`), `{"a": 1}`},
+		{"TEXT", "foo", "foo"},
+		{"BYTEA", []byte{1, 2}, []byte{1, 2}},
+		{"TIMESTAMPTZ", time.Date(2022, 6, 1, 11, 30, 0, 0, london), time.Date(2022, 6, 1, 10, 30, 0, 0, time.UTC)},
+		{"DATE", time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC), time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{"INTERVAL", []byte("1 day"), "1 day"},
+		{"INT8", nil, nil},
+	}
+	for _, test := range tests {
+		v, err := decodeColumn(test.dbType, test.value)
+		require.NoError(t, err)
+		assert.Equal(t, test.expected, v, "%v %v", test.dbType, test.value)
+	}
+
+	_, err = decodeColumn("INT8", []byte("foo"))
+	assert.Error(t, err)
+}
+
+func TestColumnTypesMeta(t *testing.T) {
+	precision, scale, nullable := int64(18), int64(2), true
+	meta := columnTypesMeta([]columnType{
+		{Name: "id", Type: "INT8"},
+		{Name: "amount", Type: "NUMERIC", Precision: &precision, Scale: &scale, Nullable: &nullable},
+	})
+	assert.Equal(t, `[{"name":"id","type":"INT8"},{"name":"amount","type":"NUMERIC","precision":18,"scale":2,"nullable":true}]`, meta)
+}
@@ -0,0 +1,371 @@
+package sql
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/benthosdev/benthos/v4/public/service"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgtype"
+)
+
+func copyField() *service.ConfigField {
+	return service.NewBoolField("copy").
+		Description("Reads the query result with `COPY (query) TO STDOUT (FORMAT binary)` over a dedicated connection and decodes the binary stream directly, which is considerably faster than scanning rows for large exports. Only supported by the postgres driver, and since `COPY` takes no parameters it can not be combined with `args_mapping`, `watermark`, `chunking` or `partitioning`.").
+		Default(false).
+		Advanced()
+}
+
+var copyBinarySignature = []byte("PGCOPY\n\377\r\n\000")
+
+// copyRows streams the result of a query through COPY in binary format.
+type copyRows struct {
+	// mu is held while a row is decoded, so that the connection isn't closed
+	// under a next in flight.
+	mu      sync.Mutex
+	conn    *pgconn.PgConn
+	pipe    *io.PipeReader
+	cancel  context.CancelFunc
+	copyErr chan error
+
+	dec *copyDecoder
+}
+
+// startCopyRows connects and starts the COPY of the query, which runs until
+// copyCtx is cancelled. cancel must cancel copyCtx.
+func startCopyRows(ctx, copyCtx context.Context, cancel context.CancelFunc, dsn, query string) (*copyRows, error) {
+	conn, err := pgconn.Connect(ctx, dsn)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	// Describing the query gives us the column names and types, which the
+	// binary COPY format doesn't include.
+	sd, err := conn.Prepare(ctx, "", trimQuery(query), nil)
+	if err != nil {
+		cancel()
+		_ = conn.Close(context.Background())
+		return nil, err
+	}
+
+	ci := pgtype.NewConnInfo()
+	columns := make([]columnType, len(sd.Fields))
+	oids := make([]uint32, len(sd.Fields))
+	for i, f := range sd.Fields {
+		columns[i] = copyColumnType(ci, f.Name, f.DataTypeOID, f.TypeModifier)
+		oids[i] = f.DataTypeOID
+	}
+
+	pr, pw := io.Pipe()
+	c := &copyRows{
+		conn:    conn,
+		pipe:    pr,
+		cancel:  cancel,
+		copyErr: make(chan error, 1),
+		dec:     newCopyDecoder(pr, ci, columns, oids),
+	}
+
+	go func() {
+		_, err := conn.CopyTo(copyCtx, pw, fmt.Sprintf("COPY (%s) TO STDOUT (FORMAT binary)", trimQuery(query)))
+		_ = pw.CloseWithError(err)
+		c.copyErr <- err
+	}()
+
+	// The header is only sent once the query starts returning rows.
+	stop := cancelWhenDone(ctx, cancel)
+	err = c.dec.readHeader()
+	stop()
+	if err != nil {
+		c.close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// next returns the next row, or io.EOF once the result has been exhausted or
+// the COPY has been closed.
+func (c *copyRows) next() (map[string]interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return nil, io.EOF
+	}
+	return c.dec.next()
+}
+
+// close stops the COPY if it is still running and closes its connection, it
+// may be called concurrently with next.
+func (c *copyRows) close() {
+	// Cancelling the COPY and closing the pipe unblock a next in flight, which
+	// then returns before the connection is closed.
+	c.cancel()
+	_ = c.pipe.Close()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return
+	}
+	<-c.copyErr
+	_ = c.conn.Close(context.Background())
+	c.conn = nil
+}
+
+// copyDecoder decodes a stream in the binary COPY format.
+type copyDecoder struct {
+	r       *bufio.Reader
+	ci      *pgtype.ConnInfo
+	columns []columnType
+	oids    []uint32
+	buf     []byte
+	eof     bool
+}
+
+func newCopyDecoder(r io.Reader, ci *pgtype.ConnInfo, columns []columnType, oids []uint32) *copyDecoder {
+	return &copyDecoder{
+		r:       bufio.NewReaderSize(r, 64*1024),
+		ci:      ci,
+		columns: columns,
+		oids:    oids,
+		buf:     make([]byte, 8),
+	}
+}
+
+func (d *copyDecoder) readHeader() error {
+	header := make([]byte, len(copyBinarySignature)+8)
+	if _, err := io.ReadFull(d.r, header); err != nil {
+		return fmt.Errorf("could not read copy header err=%v", err)
+	}
+	if !bytes.Equal(header[:len(copyBinarySignature)], copyBinarySignature) {
+		return errors.New("invalid copy header signature")
+	}
+	extLen := binary.BigEndian.Uint32(header[len(copyBinarySignature)+4:])
+	if _, err := d.r.Discard(int(extLen)); err != nil {
+		return fmt.Errorf("could not read copy header extension err=%v", err)
+	}
+	return nil
+}
+
+func (d *copyDecoder) next() (map[string]interface{}, error) {
+	if d.eof {
+		return nil, io.EOF
+	}
+
+	if _, err := io.ReadFull(d.r, d.buf[:2]); err != nil {
+		return nil, d.unexpected(err)
+	}
+	count := int16(binary.BigEndian.Uint16(d.buf[:2]))
+	if count == -1 {
+		d.eof = true
+		return nil, io.EOF
+	}
+	if int(count) != len(d.columns) {
+		return nil, fmt.Errorf("copy row has %v fields, expected %v", count, len(d.columns))
+	}
+
+	obj := make(map[string]interface{}, len(d.columns))
+	for i, c := range d.columns {
+		if _, err := io.ReadFull(d.r, d.buf[:4]); err != nil {
+			return nil, d.unexpected(err)
+		}
+		size := int32(binary.BigEndian.Uint32(d.buf[:4]))
+		if size == -1 {
+			obj[c.Name] = nil
+			continue
+		}
+
+		if cap(d.buf) < int(size) {
+			d.buf = make([]byte, size)
+		}
+		data := d.buf[:size]
+		if _, err := io.ReadFull(d.r, data); err != nil {
+			return nil, d.unexpected(err)
+		}
+
+		v, err := decodeBinary(d.ci, d.oids[i], data)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode column %v of type %v err=%v", c.Name, c.Type, err)
+		}
+		obj[c.Name] = v
+	}
+	return obj, nil
+}
+
+func (d *copyDecoder) unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func copyColumnType(ci *pgtype.ConnInfo, name []byte, oid uint32, typeModifier int32) columnType {
+	c := columnType{Name: string(name)}
+	if dt, ok := ci.DataTypeForOID(oid); ok {
+		c.Type = strings.ToUpper(dt.Name)
+	}
+	if oid == pgtype.NumericOID && typeModifier >= 4 {
+		precision := int64((typeModifier - 4) >> 16)
+		scale := int64((typeModifier - 4) & 0xffff)
+		c.Precision = &precision
+		c.Scale = &scale
+	}
+	return c
+}
+
+// decodeBinary decodes a binary encoded column value into the same Go types
+// decodeColumn normalises scanned values to. data is only valid until the
+// next row is read, so it must not be retained.
+func decodeBinary(ci *pgtype.ConnInfo, oid uint32, data []byte) (interface{}, error) {
+	switch oid {
+	case pgtype.BoolOID:
+		if len(data) != 1 {
+			return nil, fmt.Errorf("invalid length for bool: %v", len(data))
+		}
+		return data[0] != 0, nil
+	case pgtype.Int2OID:
+		if len(data) != 2 {
+			return nil, fmt.Errorf("invalid length for int2: %v", len(data))
+		}
+		return int64(int16(binary.BigEndian.Uint16(data))), nil
+	case pgtype.Int4OID:
+		if len(data) != 4 {
+			return nil, fmt.Errorf("invalid length for int4: %v", len(data))
+		}
+		return int64(int32(binary.BigEndian.Uint32(data))), nil
+	case pgtype.Int8OID:
+		if len(data) != 8 {
+			return nil, fmt.Errorf("invalid length for int8: %v", len(data))
+		}
+		return int64(binary.BigEndian.Uint64(data)), nil
+	case pgtype.Float4OID:
+		if len(data) != 4 {
+			return nil, fmt.Errorf("invalid length for float4: %v", len(data))
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(data))), nil
+	case pgtype.Float8OID:
+		if len(data) != 8 {
+			return nil, fmt.Errorf("invalid length for float8: %v", len(data))
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(data)), nil
+	case pgtype.TextOID, pgtype.VarcharOID, pgtype.BPCharOID, pgtype.NameOID, pgtype.JSONOID:
+		return string(data), nil
+	case pgtype.JSONBOID:
+		if len(data) == 0 || data[0] != 1 {
+			return nil, errors.New("unknown jsonb format")
+		}
+		return string(data[1:]), nil
+	case pgtype.UUIDOID:
+		if len(data) != 16 {
+			return nil, fmt.Errorf("invalid length for uuid: %v", len(data))
+		}
+		h := hex.EncodeToString(data)
+		return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32], nil
+	case pgtype.ByteaOID:
+		return append([]byte(nil), data...), nil
+	case pgtype.NumericOID:
+		return decodeNumericBinary(data)
+	case pgtype.DateOID:
+		var v pgtype.Date
+		if err := v.DecodeBinary(ci, data); err != nil {
+			return nil, err
+		}
+		return v.Time, nil
+	case pgtype.TimestampOID:
+		var v pgtype.Timestamp
+		if err := v.DecodeBinary(ci, data); err != nil {
+			return nil, err
+		}
+		return v.Time, nil
+	case pgtype.TimestamptzOID:
+		var v pgtype.Timestamptz
+		if err := v.DecodeBinary(ci, data); err != nil {
+			return nil, err
+		}
+		return v.Time.UTC(), nil
+	}
+
+	// Anything else is kept as its text form, as is the case
+	// when scanning it.
+	if dt, ok := ci.DataTypeForOID(oid); ok {
+		v := pgtype.NewValue(dt.Value)
+		dec, decOK := v.(pgtype.BinaryDecoder)
+		enc, encOK := v.(pgtype.TextEncoder)
+		if decOK && encOK {
+			if err := dec.DecodeBinary(ci, data); err != nil {
+				return nil, err
+			}
+			text, err := enc.EncodeText(ci, nil)
+			if err != nil {
+				return nil, err
+			}
+			return string(text), nil
+		}
+	}
+	return string(data), nil
+}
+
+// decodeNumericBinary formats a binary encoded numeric the way postgres does in
+// text format, as pgtype.Numeric would use an exponent notation instead.
+func decodeNumericBinary(data []byte) (interface{}, error) {
+	if len(data) < 8 {
+		return nil, fmt.Errorf("invalid length for numeric: %v", len(data))
+	}
+	ndigits := int(binary.BigEndian.Uint16(data[0:]))
+	weight := int(int16(binary.BigEndian.Uint16(data[2:])))
+	sign := binary.BigEndian.Uint16(data[4:])
+	dscale := int(binary.BigEndian.Uint16(data[6:]))
+	if len(data) != 8+2*ndigits {
+		return nil, fmt.Errorf("invalid length for numeric: %v", len(data))
+	}
+
+	switch sign {
+	case 0xC000:
+		return "NaN", nil
+	case 0xD000:
+		return "Infinity", nil
+	case 0xF000:
+		return "-Infinity", nil
+	}
+
+	digit := func(i int) int {
+		if i < 0 || i >= ndigits {
+			return 0
+		}
+		return int(binary.BigEndian.Uint16(data[8+2*i:]))
+	}
+
+	var sb strings.Builder
+	if sign == 0x4000 {
+		sb.WriteByte('-')
+	}
+	if weight < 0 {
+		sb.WriteByte('0')
+	}
+	for i := 0; i <= weight; i++ {
+		if i == 0 {
+			sb.WriteString(strconv.Itoa(digit(i)))
+		} else {
+			fmt.Fprintf(&sb, "%04d", digit(i))
+		}
+	}
+	if dscale > 0 {
+		var frac strings.Builder
+		for i := weight + 1; frac.Len() < dscale; i++ {
+			fmt.Fprintf(&frac, "%04d", digit(i))
+		}
+		sb.WriteByte('.')
+		sb.WriteString(frac.String()[:dscale])
+	}
+	return sb.String(), nil
+}
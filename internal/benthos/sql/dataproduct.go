@@ -0,0 +1,223 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/benthosdev/benthos/v4/public/service"
+	"github.com/utilitywarehouse/data-infra-pg-source/internal/benthos/dataproduct"
+)
+
+func dataProductField() *service.ConfigField {
+	return service.NewObjectField("data_product",
+		service.NewStringField("id").
+			Description("The id of the data product, as defined in the data-products-definitions.").
+			Example("${DATA_PRODUCT_ID}"),
+		service.NewStringField("table").
+			Description("The table, optionally qualified by its schema, the data points are selected from. When empty the `query` is used instead.").
+			Default("").
+			Example("public.footable"),
+		service.NewBoolField("preflight").
+			Description("Checks the columns of the source against the data points before reading any rows, failing with every missing column, column of the wrong type and nullable column of a required data point. The columns of `table` are read from `information_schema.columns`, otherwise the column types of `query` are described, which don't tell whether columns are nullable. Composite and other user defined types of `query` columns are looked up in `pg_type`.").
+			Default(false),
+		dataproduct.MappingField().
+			Description("The `mapping` of the processor the rows are written by, so that the query selects and the preflight checks the mapped source columns instead of the data point names. Mapped source columns are selected under their own name for the processor to map them, json columns as is."),
+	).
+		Description("Builds the query from the definition of a data product instead of `query`, selecting a column per data point from `table`, named after the data point and cast to the postgres type of its data point type, so that the query can't drift from the definition. Only supported by the postgres driver.").
+		Optional()
+}
+
+// DataProducts builds and checks the queries of data products.
+type DataProducts struct {
+	BuildQuery   dataproduct.QueryBuilder
+	CheckColumns dataproduct.ColumnChecker
+}
+
+type dataProductSource struct {
+	id        string
+	table     string
+	preflight bool
+	mapping   []dataproduct.Mapping
+}
+
+// dataProductSourceFromParsed returns nil if neither the table nor preflight
+// are set, so that the field can be templated for deployments that set a query
+// instead.
+func dataProductSourceFromParsed(conf *service.ParsedConfig) (*dataProductSource, error) {
+	id, err := conf.FieldString("id")
+	if err != nil {
+		return nil, err
+	}
+	table, err := conf.FieldString("table")
+	if err != nil {
+		return nil, err
+	}
+	preflight, err := conf.FieldBool("preflight")
+	if err != nil {
+		return nil, err
+	}
+	if table == "" && !preflight {
+		return nil, nil
+	}
+	if id == "" {
+		return nil, fmt.Errorf("data_product id must be set")
+	}
+	mappings, err := conf.FieldObjectList("mapping")
+	if err != nil {
+		return nil, err
+	}
+	mapping, err := dataproduct.MappingsFromParsed(mappings)
+	if err != nil {
+		return nil, err
+	}
+	return &dataProductSource{id: id, table: table, preflight: preflight, mapping: mapping}, nil
+}
+
+// setDataProducts builds the query of an input configured with a data product
+// table.
+func (s *sqlRawInput) setDataProducts(dataProducts DataProducts) error {
+	if s.source == nil {
+		return nil
+	}
+	if s.source.preflight {
+		if dataProducts.CheckColumns == nil {
+			return fmt.Errorf("data_product preflight checks are not supported")
+		}
+		s.check = dataProducts.CheckColumns
+	}
+	if s.source.table == "" {
+		return nil
+	}
+	if dataProducts.BuildQuery == nil {
+		return fmt.Errorf("data_product queries are not supported")
+	}
+	query, err := dataProducts.BuildQuery(s.source.id, s.source.table, s.source.mapping)
+	if err != nil {
+		return err
+	}
+	s.logger.Infof("built query of data product %v: %v", s.source.id, query)
+	s.query = query
+	return nil
+}
+
+// preflight checks the columns of the source before the query runs.
+func (s *sqlRawInput) preflight(ctx context.Context, db *sql.DB, args []interface{}) error {
+	if s.check == nil {
+		return nil
+	}
+	var columns []dataproduct.Column
+	var err error
+	if s.source.table != "" {
+		columns, err = tableColumns(ctx, db, s.source.table)
+	} else {
+		columns, err = queryColumns(ctx, db, s.query, args)
+	}
+	if err != nil {
+		return fmt.Errorf("could not read the columns of data product %v err=%v", s.source.id, err)
+	}
+	if err := s.check(s.source.id, columns, s.source.mapping); err != nil {
+		return err
+	}
+	s.logger.Infof("columns of data product %v passed the preflight check", s.source.id)
+	return nil
+}
+
+// preflightCopy checks the columns of the source over a separate connection,
+// as COPY doesn't use one of database/sql.
+func (s *sqlRawInput) preflightCopy(ctx context.Context) error {
+	if s.check == nil {
+		return nil
+	}
+	db, err := sqlOpenWithReworks(s.logger, s.driver, s.dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return s.preflight(ctx, db, nil)
+}
+
+// tableColumns reads the columns of a table from information_schema, the
+// table is in the current schema unless qualified.
+func tableColumns(ctx context.Context, db *sql.DB, table string) ([]dataproduct.Column, error) {
+	schemaFilter, args := "current_schema()", []interface{}{table}
+	if i := strings.LastIndex(table, "."); i >= 0 {
+		schemaFilter, args = "$2", []interface{}{table[i+1:], table[:i]}
+	}
+	rows, err := db.QueryContext(ctx, `SELECT c.column_name, CASE WHEN t.typtype = 'c' THEN 'record' ELSE c.udt_name END, c.is_nullable = 'YES'
+FROM information_schema.columns c
+LEFT JOIN pg_catalog.pg_namespace n ON n.nspname = c.udt_schema
+LEFT JOIN pg_catalog.pg_type t ON t.typnamespace = n.oid AND t.typname = c.udt_name
+WHERE c.table_name = $1 AND c.table_schema = `+schemaFilter+`
+ORDER BY c.ordinal_position`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []dataproduct.Column
+	for rows.Next() {
+		var c dataproduct.Column
+		var nullable bool
+		if err := rows.Scan(&c.Name, &c.Type, &nullable); err != nil {
+			return nil, err
+		}
+		c.Nullable = &nullable
+		columns = append(columns, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("table %v not found", table)
+	}
+	return columns, nil
+}
+
+// queryColumns describes the columns of a query without reading any rows.
+func queryColumns(ctx context.Context, db *sql.DB, query string, args []interface{}) ([]dataproduct.Column, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT * FROM (%v) AS uw_preflight LIMIT 0", trimQuery(query)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	types, err := sqlColumnTypes(rows)
+	if err != nil {
+		return nil, err
+	}
+	columns := make([]dataproduct.Column, len(types))
+	for i, t := range types {
+		columns[i] = dataproduct.Column{Name: t.Name, Type: strings.ToLower(t.Type), Nullable: t.Nullable}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	// The driver only names builtin types, composite and other user defined
+	// types are looked up in pg_type.
+	for i, c := range columns {
+		if c.Type != "" {
+			continue
+		}
+		if columns[i].Type, err = queryColumnType(ctx, db, query, args, c.Name); err != nil {
+			return nil, fmt.Errorf("could not look up the type of column %v err=%v", c.Name, err)
+		}
+	}
+	return columns, nil
+}
+
+// queryColumnType looks up the type of a column of a query without reading any
+// rows, composite types are reported as record like those of table columns.
+func queryColumnType(ctx context.Context, db *sql.DB, query string, args []interface{}, column string) (string, error) {
+	var typ string
+	err := db.QueryRowContext(ctx, fmt.Sprintf(`SELECT CASE WHEN t.typtype = 'c' THEN 'record' ELSE t.typname END
+FROM (SELECT 1) AS uw_one
+LEFT JOIN (SELECT * FROM (%v) AS uw_query LIMIT 0) AS uw_preflight ON true
+JOIN pg_catalog.pg_type t ON t.oid = pg_typeof(uw_preflight.%v)::oid`,
+		trimQuery(query), quoteIdentifier("postgres", column),
+	), args...).Scan(&typ)
+	return typ, err
+}
@@ -0,0 +1,169 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync"
+)
+
+// fakeDriverName is a database/sql driver serving the results of fakeDBs, so
+// that inputs can be tested through Connect and Read without a database.
+const fakeDriverName = "uw_fake"
+
+func init() {
+	sql.Register(fakeDriverName, fakeDriver{})
+}
+
+var fakeDBs sync.Map
+
+// fakeResult is the result of a query, err is returned by the query itself
+// and rowsErr once rows have been read. types are the database type names of
+// the columns, if set. A blocking query only returns once it is cancelled.
+type fakeResult struct {
+	columns  []string
+	types    []string
+	rows     [][]driver.Value
+	err      error
+	rowsErr  error
+	blocking bool
+}
+
+type fakeQuery struct {
+	query string
+	args  []interface{}
+}
+
+// fakeDB answers every query with the next result, the last result is
+// repeated once they have all been used. Statements that don't return rows
+// are only recorded.
+type fakeDB struct {
+	mu      sync.Mutex
+	results []fakeResult
+	queries []fakeQuery
+}
+
+// newFakeDB returns the DSN of a fake database answering queries with results.
+func newFakeDB(name string, results ...fakeResult) (string, *fakeDB) {
+	db := &fakeDB{results: results}
+	fakeDBs.Store(name, db)
+	return name, db
+}
+
+func (db *fakeDB) record(query string, args []driver.NamedValue) {
+	q := fakeQuery{query: query}
+	for _, a := range args {
+		q.args = append(q.args, a.Value)
+	}
+	db.queries = append(db.queries, q)
+}
+
+func (db *fakeDB) query(query string, args []driver.NamedValue) fakeResult {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.record(query, args)
+	res := db.results[0]
+	if len(db.results) > 1 {
+		db.results = db.results[1:]
+	}
+	return res
+}
+
+func (db *fakeDB) executed() []fakeQuery {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return append([]fakeQuery{}, db.queries...)
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	db, ok := fakeDBs.Load(name)
+	if !ok {
+		return nil, errors.New("unknown fake database")
+	}
+	return &fakeConn{db: db.(*fakeDB)}, nil
+}
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("prepared statements are not supported")
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return fakeTx{}, nil
+}
+
+func (c *fakeConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	return fakeTx{}, nil
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error {
+	return nil
+}
+
+func (fakeTx) Rollback() error {
+	return nil
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	res := c.db.query(query, args)
+	if res.blocking {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if res.err != nil {
+		return nil, res.err
+	}
+	return &fakeRows{res: res}, nil
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	c.db.record(query, args)
+	return driver.RowsAffected(0), nil
+}
+
+type fakeRows struct {
+	res  fakeResult
+	next int
+}
+
+func (r *fakeRows) Columns() []string {
+	return r.res.columns
+}
+
+func (r *fakeRows) ColumnTypeDatabaseTypeName(i int) string {
+	if i < len(r.res.types) {
+		return r.res.types[i]
+	}
+	return ""
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.res.rows) {
+		if r.res.rowsErr != nil {
+			return r.res.rowsErr
+		}
+		return io.EOF
+	}
+	copy(dest, r.res.rows[r.next])
+	r.next++
+	return nil
+}
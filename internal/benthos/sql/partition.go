@@ -0,0 +1,352 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/benthosdev/benthos/v4/public/service"
+)
+
+func partitioningField() *service.ConfigField {
+	return service.NewObjectField("partitioning",
+		service.NewStringField("key_column").
+			Description("The column of the query result the key ranges are computed from.").
+			Example("id"),
+		service.NewStringEnumField("key_type", "int", "numeric", "uuid", "ctid").
+			Description("The type of `key_column`. `int` keys must fit a 64 bit integer, `numeric` keys may be of any size and have a fractional part. `ctid` splits the blocks of `table` instead of the key range, `key_column` is then the ctid of `table` selected by the query, e.g. `SELECT ctid, * FROM example`. `numeric`, `uuid` and `ctid` keys are only supported by the postgres driver.").
+			Default("int"),
+		service.NewStringField("table").
+			Description("The table read by the query whose blocks, as counted by its `relpages`, are split into ranges when `key_type` is `ctid`. Blocks added since the table was last vacuumed or analyzed are read by the last range.").
+			Default("").
+			Example("public.example"),
+		service.NewIntField("partitions").
+			Description("The number of key ranges read concurrently. Make sure `conn_max_open` allows at least this many connections.").
+			Default(4),
+	).
+		Description("Splits the query result into key ranges computed from the min and max of `key_column`, or into ranges of blocks of a table, and reads them concurrently over separate connections. Rows of different ranges are interleaved in the stream.").
+		Optional().
+		Advanced()
+}
+
+type rangePartitioner struct {
+	keyColumn  string
+	keyType    string
+	table      string
+	partitions int
+}
+
+// keyRange is a range of keys including lower and excluding upper, unless it
+// is the last range of the table in which case upper is included. The last
+// range of ctid blocks has no upper bound.
+type keyRange struct {
+	lower interface{}
+	upper interface{}
+	last  bool
+}
+
+func rangePartitionerFromParsed(conf *service.ParsedConfig) (*rangePartitioner, error) {
+	keyColumn, err := conf.FieldString("key_column")
+	if err != nil {
+		return nil, err
+	}
+
+	keyType, err := conf.FieldString("key_type")
+	if err != nil {
+		return nil, err
+	}
+
+	table, err := conf.FieldString("table")
+	if err != nil {
+		return nil, err
+	}
+	if keyType == "ctid" && table == "" {
+		return nil, errors.New("partitioning by ctid requires the table read by the query")
+	}
+
+	partitions, err := conf.FieldInt("partitions")
+	if err != nil {
+		return nil, err
+	}
+	if partitions <= 0 {
+		return nil, fmt.Errorf("partitions must be greater than 0, got %v", partitions)
+	}
+
+	return &rangePartitioner{
+		keyColumn:  keyColumn,
+		keyType:    keyType,
+		table:      table,
+		partitions: partitions,
+	}, nil
+}
+
+// ranges queries the min and max keys of the query result and splits them into
+// at most the configured number of ranges.
+func (p *rangePartitioner) ranges(ctx context.Context, db *sql.DB, driver, query string, args []interface{}) ([]keyRange, error) {
+	if p.keyType == "ctid" {
+		return p.blockRanges(ctx, db)
+	}
+
+	key := "uw_part." + quoteIdentifier(driver, p.keyColumn)
+	if p.keyType == "uuid" {
+		// There is no min/max aggregate for uuid, their text form sorts the
+		// same way.
+		key += "::text"
+	}
+	boundsQuery := fmt.Sprintf("SELECT min(%s), max(%s) FROM (%s) AS uw_part", key, key, trimQuery(query))
+
+	var minKey, maxKey sql.NullString
+	if err := db.QueryRowContext(ctx, boundsQuery, args...).Scan(&minKey, &maxKey); err != nil {
+		return nil, fmt.Errorf("could not query key range of %v err=%v", p.keyColumn, err)
+	}
+	if !minKey.Valid || !maxKey.Valid {
+		return nil, nil
+	}
+
+	lower, err := p.parseKey(minKey.String, false)
+	if err != nil {
+		return nil, err
+	}
+	upper, err := p.parseKey(maxKey.String, true)
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := splitRange(lower, upper, p.partitions)
+	ranges := make([]keyRange, 0, len(bounds))
+	for i, b := range bounds {
+		r := keyRange{lower: p.formatKey(b), upper: p.formatKey(upper), last: true}
+		if i+1 < len(bounds) {
+			r.upper = p.formatKey(bounds[i+1])
+			r.last = false
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+// blockRanges splits the blocks of the table into at most the configured
+// number of ranges of ctids. relpages is only updated by vacuum and analyze, so
+// the last range is left open for the blocks added since.
+func (p *rangePartitioner) blockRanges(ctx context.Context, db *sql.DB) ([]keyRange, error) {
+	var pages int64
+	if err := db.QueryRowContext(ctx, `SELECT relpages FROM pg_catalog.pg_class WHERE oid = $1::regclass`, quoteTable(p.table)).Scan(&pages); err != nil {
+		return nil, fmt.Errorf("could not query the number of blocks of %v err=%v", p.table, err)
+	}
+	if pages <= 0 {
+		// Never vacuumed or analyzed.
+		return []keyRange{{lower: ctidOfBlock(big.NewInt(0)), last: true}}, nil
+	}
+
+	bounds := splitRange(big.NewInt(0), big.NewInt(pages-1), p.partitions)
+	ranges := make([]keyRange, 0, len(bounds))
+	for i, b := range bounds {
+		r := keyRange{lower: ctidOfBlock(b), last: true}
+		if i+1 < len(bounds) {
+			r.upper = ctidOfBlock(bounds[i+1])
+			r.last = false
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+// ctidOfBlock returns the ctid of the start of a block.
+func ctidOfBlock(block *big.Int) string {
+	return fmt.Sprintf("(%v,0)", block)
+}
+
+// rangeQuery wraps the query so that it only returns the rows of a range, args
+// are the arguments of the wrapped query.
+func (p *rangePartitioner) rangeQuery(driver, query string, args []interface{}, r keyRange) (string, []interface{}) {
+	key := "uw_part." + quoteIdentifier(driver, p.keyColumn)
+	var cast string
+	switch p.keyType {
+	case "numeric":
+		cast = "::numeric"
+	case "ctid":
+		cast = "::tid"
+	}
+
+	args = append(args, r.lower)
+	where := fmt.Sprintf("%s >= %s%s", key, placeholder(driver, len(args)), cast)
+	if r.upper != nil {
+		upperOp := "<"
+		if r.last {
+			upperOp = "<="
+		}
+		args = append(args, r.upper)
+		where += fmt.Sprintf(" AND %s %s %s%s", key, upperOp, placeholder(driver, len(args)), cast)
+	}
+	return fmt.Sprintf("SELECT * FROM (%s) AS uw_part WHERE %s", trimQuery(query), where), args
+}
+
+// parseKey parses the min or max key of the query result. Fractional numeric
+// keys are rounded outwards, down for the min and up for the max, so that the
+// ranges still cover them.
+func (p *rangePartitioner) parseKey(s string, isMax bool) (*big.Int, error) {
+	switch p.keyType {
+	case "uuid":
+		k, ok := new(big.Int).SetString(strings.ReplaceAll(s, "-", ""), 16)
+		if !ok {
+			return nil, fmt.Errorf("could not parse uuid key %v", s)
+		}
+		return k, nil
+	case "numeric":
+		r, ok := new(big.Rat).SetString(s)
+		if !ok {
+			return nil, fmt.Errorf("could not parse numeric key %v", s)
+		}
+		k := new(big.Int).Quo(r.Num(), r.Denom())
+		if !r.IsInt() && (r.Sign() < 0) != isMax {
+			// Quo truncates towards zero.
+			k.Add(k, big.NewInt(int64(r.Sign())))
+		}
+		return k, nil
+	}
+
+	k, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return nil, fmt.Errorf("could not parse int key %v, use key_type numeric for keys with a fractional part", s)
+	}
+	if !k.IsInt64() {
+		return nil, fmt.Errorf("int key %v doesn't fit a 64 bit integer, use key_type numeric", s)
+	}
+	return k, nil
+}
+
+func (p *rangePartitioner) formatKey(k *big.Int) interface{} {
+	switch p.keyType {
+	case "uuid":
+		h := fmt.Sprintf("%032x", k)
+		return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32]
+	case "numeric":
+		return k.String()
+	}
+	return k.Int64()
+}
+
+// splitRange returns the distinct lower bounds of at most n ranges evenly
+// covering [lower, upper].
+func splitRange(lower, upper *big.Int, n int) []*big.Int {
+	span := new(big.Int).Sub(upper, lower)
+	span.Add(span, big.NewInt(1))
+
+	bounds := make([]*big.Int, 0, n)
+	for i := 0; i < n; i++ {
+		b := new(big.Int).Mul(span, big.NewInt(int64(i)))
+		b.Div(b, big.NewInt(int64(n)))
+		b.Add(b, lower)
+		if len(bounds) > 0 && bounds[len(bounds)-1].Cmp(b) == 0 {
+			continue
+		}
+		bounds = append(bounds, b)
+	}
+	return bounds
+}
+
+type partitionResult struct {
+	row     map[string]interface{}
+	columns []columnType
+	meta    string
+	err     error
+}
+
+// partitionedRows reads a set of queries concurrently and merges their rows.
+type partitionedRows struct {
+	results chan partitionResult
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// startPartitionedRows starts reading the queries, they are cancelled when ctx
+// is and each of them once it has run for longer than timeout (if > 0).
+func startPartitionedRows(ctx context.Context, db *sql.DB, queries []string, args [][]interface{}, timeout time.Duration) *partitionedRows {
+	ctx, cancel := context.WithCancel(ctx)
+	p := &partitionedRows{
+		results: make(chan partitionResult, len(queries)),
+		cancel:  cancel,
+	}
+
+	for i := range queries {
+		p.wg.Add(1)
+		go func(query string, args []interface{}) {
+			defer p.wg.Done()
+			if err := p.read(ctx, db, query, args, timeout); err != nil {
+				select {
+				case p.results <- partitionResult{err: err}:
+				case <-ctx.Done():
+				}
+			}
+		}(queries[i], args[i])
+	}
+
+	go func() {
+		p.wg.Wait()
+		close(p.results)
+	}()
+	return p
+}
+
+func (p *partitionedRows) read(ctx context.Context, db *sql.DB, query string, args []interface{}, timeout time.Duration) error {
+	queryCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		queryCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	rows, err := db.QueryContext(queryCtx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	columns, err := sqlColumnTypes(rows)
+	if err != nil {
+		return err
+	}
+	meta := columnTypesMeta(columns)
+
+	for rows.Next() {
+		obj, err := sqlRowToMap(rows, columns)
+		if err != nil {
+			return err
+		}
+		select {
+		case p.results <- partitionResult{row: obj, columns: columns, meta: meta}:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	return rows.Err()
+}
+
+// next returns the next row of any partition along with its column types and
+// their metadata, or false once every partition has been exhausted.
+func (p *partitionedRows) next(ctx context.Context) (map[string]interface{}, []columnType, string, bool, error) {
+	select {
+	case res, open := <-p.results:
+		if !open {
+			return nil, nil, "", false, nil
+		}
+		return res.row, res.columns, res.meta, true, res.err
+	case <-ctx.Done():
+		return nil, nil, "", true, ctx.Err()
+	}
+}
+
+// close stops the partition queries and waits for them to return.
+func (p *partitionedRows) close() {
+	p.cancel()
+	p.wg.Wait()
+}
+
+func trimQuery(query string) string {
+	return strings.TrimRight(strings.TrimSpace(query), ";")
+}
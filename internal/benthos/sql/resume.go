@@ -0,0 +1,131 @@
+package sql
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/benthosdev/benthos/v4/public/service"
+	"github.com/cenkalti/backoff/v4"
+	"github.com/lib/pq"
+)
+
+func resumeField() *service.ConfigField {
+	return service.NewObjectField("resume",
+		service.NewStringField("key_column").
+			Description("A unique, orderable column of the query result. Rows are read ordered by it, so that an interrupted query can be resumed after the last key read. When `chunking` is also set it must be the same column.").
+			Example("id"),
+		service.NewIntField("max_retries").
+			Description("The maximum number of consecutive attempts to resume the query, the count is reset once a row has been read. If value <= 0, attempts are only bounded by `backoff.max_elapsed_time`.").
+			Default(5),
+		service.NewBackOffField("backoff", false, &backoff.ExponentialBackOff{
+			InitialInterval: time.Second,
+			MaxInterval:     30 * time.Second,
+			MaxElapsedTime:  5 * time.Minute,
+		}),
+	).
+		Description("Resumes the query after a transient error, such as a dropped connection or the server shutting down, from the last key read instead of ending the export early. Once the retries are exhausted the error is returned by every following read, so that the export fails.").
+		Optional().
+		Advanced()
+}
+
+type resumer struct {
+	keyColumn  string
+	maxRetries int
+	backoff    *backoff.ExponentialBackOff
+
+	retries   int
+	exhausted bool
+}
+
+func resumerFromParsed(conf *service.ParsedConfig) (*resumer, error) {
+	keyColumn, err := conf.FieldString("key_column")
+	if err != nil {
+		return nil, err
+	}
+
+	maxRetries, err := conf.FieldInt("max_retries")
+	if err != nil {
+		return nil, err
+	}
+
+	boff, err := conf.FieldBackOff("backoff")
+	if err != nil {
+		return nil, err
+	}
+
+	return &resumer{
+		keyColumn:  keyColumn,
+		maxRetries: maxRetries,
+		backoff:    boff,
+	}, nil
+}
+
+// wait blocks for the backoff interval if the query should be resumed after
+// err, otherwise it returns why it shouldn't be.
+func (r *resumer) wait(ctx context.Context, err error) error {
+	if !isTransientErr(err) {
+		return errors.New("error is not transient")
+	}
+	if r.maxRetries > 0 && r.retries >= r.maxRetries {
+		r.exhausted = true
+		return fmt.Errorf("gave up after %v retries", r.retries)
+	}
+
+	if r.retries == 0 {
+		r.backoff.Reset()
+	}
+	interval := r.backoff.NextBackOff()
+	if interval == backoff.Stop {
+		r.exhausted = true
+		return fmt.Errorf("gave up after %v", r.backoff.MaxElapsedTime)
+	}
+	r.retries++
+
+	select {
+	case <-time.After(interval):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
+
+// progress records that a row has been read since the last retry.
+func (r *resumer) progress() {
+	r.retries = 0
+}
+
+// isTransientErr reports whether err is caused by the connection or the server
+// rather than the query, so that running the query again may succeed.
+func isTransientErr(err error) bool {
+	// Cancellation and the statement timeout are deliberate.
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		if pqErr.Code.Class() == "08" {
+			// connection_exception
+			return true
+		}
+		switch pqErr.Code {
+		case "57P01", "57P02", "57P03", "53300", "40001", "40P01":
+			// admin_shutdown, crash_shutdown, cannot_connect_now,
+			// too_many_connections, serialization_failure and
+			// deadlock_detected
+			return true
+		}
+		return false
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
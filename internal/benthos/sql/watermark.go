@@ -0,0 +1,274 @@
+package sql
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/benthosdev/benthos/v4/public/service"
+)
+
+func watermarkField() *service.ConfigField {
+	return service.NewObjectField("watermark",
+		service.NewStringField("column").
+			Description("The column of the query result used as the high-watermark, usually an `updated_at` timestamp or an increasing bigint id.").
+			Example("updated_at"),
+		service.NewStringField("initial_value").
+			Description("The value passed to the query when no watermark has been committed yet.").
+			Example("1970-01-01T00:00:00Z").
+			Example("0"),
+		service.NewStringField("state_table").
+			Description("The postgres table the committed watermarks are stored in, optionally qualified by its schema, e.g. `etl.watermarks`. It is created if it does not exist.").
+			Default("uw_sql_raw_watermarks"),
+		service.NewStringField("state_key").
+			Description("The key the watermark of this query is stored under, it must be unique per extraction.").
+			Example("footable"),
+	).
+		Description("Enables incremental extraction. The last committed watermark is passed to the query as the argument following those of `args_mapping` (`$1` when there are none) and the highest value of `column` seen is committed to `state_table` once every row of the run has been acknowledged.").
+		Optional()
+}
+
+type watermark struct {
+	column       string
+	initialValue string
+	stateTable   string
+	stateKey     string
+
+	mu      sync.Mutex
+	last    string
+	high    interface{}
+	pending int
+	eof     bool
+}
+
+func watermarkFromParsed(conf *service.ParsedConfig) (*watermark, error) {
+	column, err := conf.FieldString("column")
+	if err != nil {
+		return nil, err
+	}
+
+	initialValue, err := conf.FieldString("initial_value")
+	if err != nil {
+		return nil, err
+	}
+
+	stateTable, err := conf.FieldString("state_table")
+	if err != nil {
+		return nil, err
+	}
+
+	stateKey, err := conf.FieldString("state_key")
+	if err != nil {
+		return nil, err
+	}
+
+	return &watermark{
+		column:       column,
+		initialValue: initialValue,
+		stateTable:   stateTable,
+		stateKey:     stateKey,
+	}, nil
+}
+
+// load creates the state table if required and reads the last committed
+// watermark, falling back to the initial value.
+func (w *watermark) load(ctx context.Context, db *sql.DB) error {
+	if _, err := db.ExecContext(ctx, fmt.Sprintf(
+		`CREATE TABLE IF NOT EXISTS %s (key text PRIMARY KEY, value text NOT NULL, updated_at timestamptz NOT NULL DEFAULT now())`,
+		quoteTable(w.stateTable),
+	)); err != nil {
+		return fmt.Errorf("could not create watermark state table %v err=%v", w.stateTable, err)
+	}
+
+	var last string
+	err := db.QueryRowContext(ctx, fmt.Sprintf(`SELECT value FROM %s WHERE key = $1`, quoteTable(w.stateTable)), w.stateKey).Scan(&last)
+	switch {
+	case err == sql.ErrNoRows:
+		last = w.initialValue
+	case err != nil:
+		return fmt.Errorf("could not read watermark %v err=%v", w.stateKey, err)
+	}
+
+	w.mu.Lock()
+	w.last = last
+	w.high = nil
+	w.pending = 0
+	w.eof = false
+	w.mu.Unlock()
+	return nil
+}
+
+// lastValue returns the watermark the current run started from.
+func (w *watermark) lastValue() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.last
+}
+
+// observe records a row that has been read but not yet acknowledged, columns
+// are the types of the columns of the row.
+func (w *watermark) observe(row map[string]interface{}, columns []columnType) error {
+	v, ok := row[w.column]
+	if !ok {
+		return fmt.Errorf("watermark column %v not present in query result", w.column)
+	}
+	if v == nil {
+		return fmt.Errorf("watermark column %v must not be null", w.column)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.high != nil {
+		cmp, err := compareWatermarks(v, w.high, columnTypeOf(columns, w.column))
+		if err != nil {
+			return err
+		}
+		if cmp <= 0 {
+			w.pending++
+			return nil
+		}
+	}
+	w.high = v
+	w.pending++
+	return nil
+}
+
+// ack resolves a row previously passed to observe.
+func (w *watermark) ack() {
+	w.mu.Lock()
+	w.pending--
+	w.mu.Unlock()
+}
+
+// done marks the query as exhausted.
+func (w *watermark) done() {
+	w.mu.Lock()
+	w.eof = true
+	w.mu.Unlock()
+}
+
+// commit persists the highest watermark seen, but only when the query has
+// been exhausted and every row read has been acknowledged.
+func (w *watermark) commit(ctx context.Context, db *sql.DB) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.eof || w.pending > 0 || w.high == nil {
+		return nil
+	}
+
+	value, err := formatWatermark(w.high)
+	if err != nil {
+		return err
+	}
+
+	if _, err := db.ExecContext(ctx, fmt.Sprintf(
+		`INSERT INTO %s (key, value) VALUES ($1, $2) ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, updated_at = now()`,
+		quoteTable(w.stateTable),
+	), w.stateKey, value); err != nil {
+		return fmt.Errorf("could not commit watermark %v err=%v", w.stateKey, err)
+	}
+	w.last = value
+	w.high = nil
+	return nil
+}
+
+// quoteTable quotes the parts of a table name, which is qualified by its
+// schema if it contains a dot.
+func quoteTable(name string) string {
+	parts := strings.Split(name, ".")
+	for i, p := range parts {
+		parts[i] = quoteIdentifier("postgres", p)
+	}
+	return strings.Join(parts, ".")
+}
+
+// compareWatermarks compares two values of a column of type dbType. Numeric
+// columns are decoded as text and compared exactly, as they may not fit a
+// float64.
+func compareWatermarks(a, b interface{}, dbType string) (int, error) {
+	switch av := a.(type) {
+	case int64:
+		if bv, ok := b.(int64); ok {
+			switch {
+			case av < bv:
+				return -1, nil
+			case av > bv:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	case float64:
+		if bv, ok := b.(float64); ok {
+			return sign(av - bv), nil
+		}
+	case string:
+		if bv, ok := b.(string); ok {
+			if dbType != "NUMERIC" && dbType != "DECIMAL" {
+				return strings.Compare(av, bv), nil
+			}
+			ar, aOk := new(big.Rat).SetString(av)
+			br, bOk := new(big.Rat).SetString(bv)
+			if !aOk || !bOk {
+				return 0, fmt.Errorf("can not compare numeric watermark values %v and %v", av, bv)
+			}
+			return ar.Cmp(br), nil
+		}
+	case []byte:
+		if bv, ok := b.([]byte); ok {
+			return bytes.Compare(av, bv), nil
+		}
+	case time.Time:
+		if bv, ok := b.(time.Time); ok {
+			switch {
+			case av.Before(bv):
+				return -1, nil
+			case av.After(bv):
+				return 1, nil
+			}
+			return 0, nil
+		}
+	}
+	return 0, fmt.Errorf("can not compare watermark values of type %T and %T", a, b)
+}
+
+// columnTypeOf returns the database type of a column, or "" if unknown.
+func columnTypeOf(columns []columnType, name string) string {
+	for _, c := range columns {
+		if c.Name == name {
+			return c.Type
+		}
+	}
+	return ""
+}
+
+func sign(f float64) int {
+	switch {
+	case f < 0:
+		return -1
+	case f > 0:
+		return 1
+	}
+	return 0
+}
+
+func formatWatermark(v interface{}) (string, error) {
+	switch t := v.(type) {
+	case int64:
+		return strconv.FormatInt(t, 10), nil
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64), nil
+	case string:
+		return t, nil
+	case []byte:
+		return string(t), nil
+	case time.Time:
+		return t.Format(time.RFC3339Nano), nil
+	}
+	return "", fmt.Errorf("unsupported watermark type %T", v)
+}